@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+type AssertCmd struct {
+	CmdCommon
+
+	Rules     []string `optional:"" name:"rule" short:"r" sep:"none" help:"Rule to check, e.g. \"p99 of /pkg.Svc/Method < 200ms\", \"error rate < 0.1%\", \"no DeadlineExceeded\""`
+	RulesFile string   `optional:"" type:"existingfile" help:"File containing one rule per line; empty lines and lines starting with # are ignored"`
+	Output    string   `optional:"" enum:"table,csv,json" default:"table" help:"Output format (table, csv, json)"`
+}
+
+// A rule compares a metric computed over the calls matching a method pattern against a threshold.
+//
+// Syntax:
+//
+//	<metric> [of <method pattern>] <op> <value>
+//	no <status code> [of <method pattern>]
+//
+// where metric is one of p50, p90, p95, p99, p999, max, mean, error rate, errors or calls,
+// op is one of <, <=, >, >=, ==, != and the method pattern is a glob, e.g. /pkg.Svc/*.
+type rule struct {
+	text    string
+	metric  string
+	code    codes.Code
+	pattern string
+	op      string
+	value   float64
+}
+
+func parseRule(text string) (*rule, error) {
+	fields := strings.Fields(text)
+	r := &rule{text: text}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty rule")
+	}
+
+	if strings.ToLower(fields[0]) == "no" {
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%q: expected a status code after \"no\"", text)
+		}
+		if strings.ToLower(fields[1]) == "errors" {
+			r.metric = "errors"
+		} else {
+			code, ok := parseCode(fields[1])
+			if !ok {
+				return nil, fmt.Errorf("%q: unknown status code %q", text, fields[1])
+			}
+			r.metric, r.code = "code", code
+		}
+		fields = fields[2:]
+		if len(fields) >= 2 && strings.ToLower(fields[0]) == "of" {
+			r.pattern = fields[1]
+			fields = fields[2:]
+		}
+		if len(fields) != 0 {
+			return nil, fmt.Errorf("%q: unexpected %q", text, strings.Join(fields, " "))
+		}
+		r.op, r.value = "==", 0
+		return r, nil
+	}
+
+	r.metric = strings.ToLower(fields[0])
+	fields = fields[1:]
+	if r.metric == "error" && len(fields) > 0 && strings.ToLower(fields[0]) == "rate" {
+		r.metric = "error-rate"
+		fields = fields[1:]
+	}
+	switch r.metric {
+	case "p50", "p90", "p95", "p99", "p999", "max", "mean", "error-rate", "errors", "calls":
+	default:
+		return nil, fmt.Errorf("%q: unknown metric %q", text, r.metric)
+	}
+	if len(fields) >= 2 && strings.ToLower(fields[0]) == "of" {
+		r.pattern = fields[1]
+		fields = fields[2:]
+	}
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("%q: expected <op> <value>", text)
+	}
+	switch fields[0] {
+	case "<", "<=", ">", ">=", "==", "!=":
+		r.op = fields[0]
+	default:
+		return nil, fmt.Errorf("%q: unknown operator %q", text, fields[0])
+	}
+	v, err := r.parseValue(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", text, err)
+	}
+	r.value = v
+	return r, nil
+}
+
+// parseValue parses durations into seconds and percentages into fractions.
+func (r *rule) parseValue(s string) (float64, error) {
+	switch r.metric {
+	case "error-rate":
+		if strings.HasSuffix(s, "%") {
+			v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+			return v / 100, err
+		}
+		return strconv.ParseFloat(s, 64)
+	case "errors", "calls":
+		return strconv.ParseFloat(s, 64)
+	default:
+		d, err := time.ParseDuration(s)
+		return d.Seconds(), err
+	}
+}
+
+func (r *rule) formatValue(v float64) string {
+	switch r.metric {
+	case "error-rate":
+		return fmt.Sprintf("%.3f%%", v*100)
+	case "errors", "calls", "code":
+		return fmt.Sprint(v)
+	default:
+		return fmt.Sprint(time.Duration(v * float64(time.Second)))
+	}
+}
+
+func parseCode(name string) (codes.Code, bool) {
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if strings.EqualFold(c.String(), name) {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+func (r *rule) matches(method string) bool {
+	if r.pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(r.pattern, method)
+	return ok || r.pattern == method
+}
+
+// evaluate returns the actual value of the metric and whether the rule holds.
+func (r *rule) evaluate(conversations []conversation) (float64, bool, error) {
+	var s callStats
+	for _, c := range conversations {
+		if c.CallId() != 0 && r.matches(c.MethodName()) {
+			s.record(c)
+		}
+	}
+
+	var actual float64
+	switch r.metric {
+	case "calls":
+		actual = float64(s.calls)
+	case "errors":
+		actual = float64(s.errors)
+	case "code":
+		actual = float64(s.codes[r.code])
+	default:
+		if s.completed == 0 {
+			return 0, false, fmt.Errorf("no completed calls matched")
+		}
+		switch r.metric {
+		case "error-rate":
+			actual = s.errorRate()
+		case "mean":
+			var sum time.Duration
+			for _, l := range s.latencies {
+				sum += l
+			}
+			actual = (sum / time.Duration(len(s.latencies))).Seconds()
+		case "max":
+			actual = s.percentile(1).Seconds()
+		default:
+			p, _ := strconv.ParseFloat("0."+strings.TrimPrefix(r.metric, "p"), 64)
+			actual = s.percentile(p).Seconds()
+		}
+	}
+
+	var ok bool
+	switch r.op {
+	case "<":
+		ok = actual < r.value
+	case "<=":
+		ok = actual <= r.value
+	case ">":
+		ok = actual > r.value
+	case ">=":
+		ok = actual >= r.value
+	case "==":
+		ok = actual == r.value
+	case "!=":
+		ok = actual != r.value
+	}
+	return actual, ok, nil
+}
+
+func (cmd *AssertCmd) loadRules() ([]*rule, error) {
+	texts := append([]string{}, cmd.Rules...)
+	if cmd.RulesFile != "" {
+		f, err := os.Open(cmd.RulesFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			texts = append(texts, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("no rules given, use --rule or --rules-file")
+	}
+
+	var res []*rule
+	for _, t := range texts {
+		r, err := parseRule(t)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+func (cmd *AssertCmd) Run(cli *Context) error {
+	rules, err := cmd.loadRules()
+	if err != nil {
+		return err
+	}
+
+	f, err := openFile(cmd.LogInputFile, cli.Follow)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	conversations, err := readConversations(cli, f)
+	if err != nil {
+		return err
+	}
+
+	t := &table{header: []string{"Rule", "Actual", "Result"}}
+	var violations int
+	for _, r := range rules {
+		actual, ok, err := r.evaluate(conversations)
+		switch {
+		case err != nil:
+			violations++
+			t.append(r.text, err.Error(), "FAIL")
+		case !ok:
+			violations++
+			t.append(r.text, r.formatValue(actual), "FAIL")
+		default:
+			t.append(r.text, r.formatValue(actual), "PASS")
+		}
+	}
+	if err := t.write(os.Stdout, cmd.Output); err != nil {
+		return err
+	}
+	if violations > 0 {
+		return fmt.Errorf("%d of %d rules violated", violations, len(rules))
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	v1 "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func testConversation(id uint64, method string, elapsed time.Duration, code codes.Code) conversation {
+	start := time.Date(2022, 1, 26, 12, 0, 0, 0, time.UTC)
+	return conversation{
+		requestHeader: &v1.GrpcLogEntry{
+			CallId:    id,
+			Timestamp: timestamppb.New(start),
+			Type:      v1.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER,
+			Payload:   &v1.GrpcLogEntry_ClientHeader{ClientHeader: &v1.ClientHeader{MethodName: method}},
+		},
+		responseTrailer: &v1.GrpcLogEntry{
+			CallId:    id,
+			Timestamp: timestamppb.New(start.Add(elapsed)),
+			Type:      v1.GrpcLogEntry_EVENT_TYPE_SERVER_TRAILER,
+			Payload:   &v1.GrpcLogEntry_Trailer{Trailer: &v1.Trailer{StatusCode: uint32(code)}},
+		},
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		text, metric, pattern, op string
+		code                      codes.Code
+		value                     float64
+	}{
+		{"p99 of /pkg.Svc/Method < 200ms", "p99", "/pkg.Svc/Method", "<", 0, 0.2},
+		{"p999 <= 1s", "p999", "", "<=", 0, 1},
+		{"max of /pkg.Svc/* != 1m", "max", "/pkg.Svc/*", "!=", 0, 60},
+		{"error rate < 0.1%", "error-rate", "", "<", 0, 0.001},
+		{"Error Rate of /pkg.Svc/* >= 0.5", "error-rate", "/pkg.Svc/*", ">=", 0, 0.5},
+		{"calls > 10", "calls", "", ">", 0, 10},
+		{"no errors", "errors", "", "==", 0, 0},
+		{"no DeadlineExceeded", "code", "", "==", codes.DeadlineExceeded, 0},
+		{"no unavailable of /pkg.Svc/*", "code", "/pkg.Svc/*", "==", codes.Unavailable, 0},
+	}
+	for _, test := range tests {
+		r, err := parseRule(test.text)
+		if err != nil {
+			t.Errorf("%q: %v", test.text, err)
+			continue
+		}
+		if r.metric != test.metric || r.pattern != test.pattern || r.op != test.op || r.code != test.code || r.value != test.value {
+			t.Errorf("%q: got %+v", test.text, *r)
+		}
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"no",
+		"no Bogus",
+		"no errors of /pkg.Svc/* please",
+		"p42 < 1s",
+		"error < 1%",
+		"p99 < ",
+		"p99 of /pkg.Svc/Method",
+		"p99 =< 1s",
+		"p99 < 200",
+		"error rate < lots",
+	} {
+		if _, err := parseRule(text); err == nil {
+			t.Errorf("%q: want error", text)
+		}
+	}
+}
+
+func TestRuleEvaluate(t *testing.T) {
+	var conversations []conversation
+	// 1000 calls to /a.B/X taking 1ms to 1s, the slowest of which fails
+	for i := 1; i <= 1000; i++ {
+		code := codes.OK
+		if i == 1000 {
+			code = codes.DeadlineExceeded
+		}
+		conversations = append(conversations, testConversation(uint64(i), "/a.B/X", time.Duration(i)*time.Millisecond, code))
+	}
+	conversations = append(conversations, testConversation(1001, "/a.B/Y", 5*time.Second, codes.OK))
+
+	tests := []struct {
+		text   string
+		actual float64
+		ok     bool
+	}{
+		{"calls == 1001", 1001, true},
+		{"calls of /a.B/* == 1001", 1001, true},
+		{"calls of /a.B/Y == 1", 1, true},
+		{"p50 of /a.B/X <= 500ms", 0.5, true},
+		{"p99 of /a.B/X < 990ms", 0.99, false},
+		{"p999 of /a.B/X == 999ms", 0.999, true},
+		{"max < 1s", 5, false},
+		{"mean of /a.B/Y == 5s", 5, true},
+		{"error rate of /a.B/X < 0.1%", 0.001, false},
+		{"error rate of /a.B/X <= 0.1%", 0.001, true},
+		{"errors of /a.B/Y == 0", 0, true},
+		{"no errors", 1, false},
+		{"no DeadlineExceeded of /a.B/Y", 0, true},
+		{"no DeadlineExceeded", 1, false},
+	}
+	for _, test := range tests {
+		r, err := parseRule(test.text)
+		if err != nil {
+			t.Fatalf("%q: %v", test.text, err)
+		}
+		actual, ok, err := r.evaluate(conversations)
+		if err != nil {
+			t.Errorf("%q: %v", test.text, err)
+			continue
+		}
+		if actual != test.actual || ok != test.ok {
+			t.Errorf("%q: got %v %v, want %v %v", test.text, actual, ok, test.actual, test.ok)
+		}
+	}
+
+	r, _ := parseRule("p99 of /c.D/* < 1s")
+	if _, _, err := r.evaluate(conversations); err == nil {
+		t.Errorf("%q: want an error when no calls match", r.text)
+	}
+}
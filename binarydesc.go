@@ -0,0 +1,140 @@
+package main
+
+import (
+	"debug/elf"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// binaryFileDescriptors extracts the file descriptors embedded in a Go ELF binary.
+//
+// Code generated by protoc-gen-go embeds each file descriptor in its serialized form (rawDesc),
+// which starts with the file name field. We scan the data sections for byte sequences that look like
+// the beginning of a serialized FileDescriptorProto and keep those that parse into a valid descriptor.
+func binaryFileDescriptors(filename string) ([]*descriptorpb.FileDescriptorProto, error) {
+	f, err := elf.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("not an ELF binary: %w", err)
+	}
+	defer f.Close()
+
+	var res []*descriptorpb.FileDescriptorProto
+	seen := map[string]bool{}
+	for _, s := range f.Sections {
+		if s.Type != elf.SHT_PROGBITS || s.Flags&elf.SHF_ALLOC == 0 || s.Flags&elf.SHF_EXECINSTR != 0 {
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			return nil, fmt.Errorf("section %s: %w", s.Name, err)
+		}
+		for i := 0; i < len(data); i++ {
+			if !looksLikeFileDescriptor(data[i:]) {
+				continue
+			}
+			fdp, n := parseEmbeddedFileDescriptor(data[i:])
+			if fdp == nil {
+				continue
+			}
+			if !seen[fdp.GetName()] {
+				seen[fdp.GetName()] = true
+				res = append(res, fdp)
+			}
+			i += n - 1
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no file descriptors found in %s", filename)
+	}
+	return res, nil
+}
+
+// looksLikeFileDescriptor returns true if b starts with a FileDescriptorProto name field
+// holding a plausible proto file name.
+func looksLikeFileDescriptor(b []byte) bool {
+	if len(b) < 2 || b[0] != 0x0a {
+		return false
+	}
+	name, n := protowire.ConsumeBytes(b[1:])
+	if n < 0 || len(name) < len("a.proto") || len(name) > 512 || !strings.HasSuffix(string(name), ".proto") {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// parseEmbeddedFileDescriptor parses the file descriptor at the beginning of b, whose end is unknown.
+// It returns the descriptor and its length in bytes, or nil if no valid descriptor could be parsed.
+func parseEmbeddedFileDescriptor(b []byte) (*descriptorpb.FileDescriptorProto, int) {
+	// collect the offsets at which each plausible FileDescriptorProto field ends.
+	// Descriptors are serialized by protoc in field number order, so the descriptor
+	// must end before a field with a lower number or a repeated singular field.
+	var (
+		ends []int
+		last protowire.Number
+	)
+	for off := 0; off < len(b); {
+		num, typ, n := protowire.ConsumeTag(b[off:])
+		if n < 0 || !fileDescriptorField(num, typ) || num < last || (num == last && !repeatedFileDescriptorField(num)) {
+			break
+		}
+		last = num
+		m := protowire.ConsumeFieldValue(num, typ, b[off+n:])
+		if m < 0 {
+			break
+		}
+		off += n + m
+		ends = append(ends, off)
+	}
+
+	// the bytes following the descriptor may happen to look like valid fields;
+	// back off until we find a prefix that is a valid descriptor.
+	const maxAttempts = 8
+	for i := len(ends) - 1; i >= 0 && i >= len(ends)-maxAttempts; i-- {
+		var fdp descriptorpb.FileDescriptorProto
+		if err := proto.Unmarshal(b[:ends[i]], &fdp); err != nil {
+			continue
+		}
+		if _, err := (protodesc.FileOptions{AllowUnresolvable: true}).New(&fdp, &protoregistry.Files{}); err != nil {
+			continue
+		}
+		return &fdp, ends[i]
+	}
+	return nil, 0
+}
+
+// repeatedFileDescriptorField returns true if num is a repeated field of FileDescriptorProto.
+func repeatedFileDescriptorField(num protowire.Number) bool {
+	switch num {
+	case 3, 4, 5, 6, 7, 10, 11:
+		return true
+	default:
+		return false
+	}
+}
+
+// fileDescriptorField returns true if num is a field of FileDescriptorProto with wire type typ.
+func fileDescriptorField(num protowire.Number, typ protowire.Type) bool {
+	switch num {
+	case 1, 2, 3, 4, 5, 6, 7, 8, 9, 12, 13:
+		return typ == protowire.BytesType
+	case 10, 11:
+		// public_dependency and weak_dependency, possibly packed.
+		return typ == protowire.VarintType || typ == protowire.BytesType
+	case 14:
+		// edition
+		return typ == protowire.VarintType
+	default:
+		return false
+	}
+}
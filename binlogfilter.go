@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	v1 "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// A binaryLogConfig is a parsed GRPC_BINARY_LOG_FILTER value, which selects the methods gRPC logs
+// and how much of their headers and messages is logged. It follows grpc-go's internal/binarylog.
+//
+//	*{h:256;m:1024},pkg.Svc/*,-pkg.Svc/Method,pkg.Other/Method{m}
+type binaryLogConfig struct {
+	all       *binaryLogLimits
+	services  map[string]*binaryLogLimits
+	methods   map[string]*binaryLogLimits
+	blacklist map[string]bool
+}
+
+// binaryLogLimits are the maximum number of metadata and message bytes logged, noLimit if unbounded.
+type binaryLogLimits struct {
+	header, message uint64
+}
+
+const noLimit = ^uint64(0)
+
+var (
+	methodConfigPattern = regexp.MustCompile(`^([\w./]+)/((?:\w+)|[*])(.+)?$`)
+	headerLimitPattern  = regexp.MustCompile(`^{h(?::(\d+))?}$`)
+	messageLimitPattern = regexp.MustCompile(`^{m(?::(\d+))?}$`)
+	bothLimitsPattern   = regexp.MustCompile(`^{h(?::(\d+))?;m(?::(\d+))?}$`)
+)
+
+func parseBinaryLogConfig(s string) (*binaryLogConfig, error) {
+	c := &binaryLogConfig{services: map[string]*binaryLogLimits{}, methods: map[string]*binaryLogLimits{}, blacklist: map[string]bool{}}
+	for _, rule := range strings.Split(s, ",") {
+		if err := c.add(rule); err != nil {
+			return nil, fmt.Errorf("invalid binary log config %q: %w", rule, err)
+		}
+	}
+	return c, nil
+}
+
+func (c *binaryLogConfig) add(rule string) error {
+	switch {
+	case rule == "":
+		return fmt.Errorf("empty rule")
+	case rule[0] == '-':
+		service, method, suffix, ok := splitMethodRule(rule[1:])
+		switch {
+		case !ok:
+			return fmt.Errorf("invalid method")
+		case method == "*":
+			return fmt.Errorf("* not allowed in blacklist rules")
+		case suffix != "":
+			return fmt.Errorf("header/message limits not allowed in blacklist rules")
+		}
+		name := service + "/" + method
+		if c.blacklist[name] || c.methods[name] != nil {
+			return fmt.Errorf("conflicting rules for method %s", name)
+		}
+		c.blacklist[name] = true
+	case rule[0] == '*':
+		limits, err := parseBinaryLogLimits(rule[1:])
+		if err != nil {
+			return err
+		}
+		if c.all != nil {
+			return fmt.Errorf("conflicting global rules")
+		}
+		c.all = limits
+	default:
+		service, method, suffix, ok := splitMethodRule(rule)
+		if !ok {
+			return fmt.Errorf("invalid method")
+		}
+		limits, err := parseBinaryLogLimits(suffix)
+		if err != nil {
+			return err
+		}
+		if method == "*" {
+			if c.services[service] != nil {
+				return fmt.Errorf("conflicting rules for service %s", service)
+			}
+			c.services[service] = limits
+			return nil
+		}
+		name := service + "/" + method
+		if c.blacklist[name] || c.methods[name] != nil {
+			return fmt.Errorf("conflicting rules for method %s", name)
+		}
+		c.methods[name] = limits
+	}
+	return nil
+}
+
+// splitMethodRule splits "pkg.Svc/Method{h;m}" into "pkg.Svc", "Method" and "{h;m}".
+func splitMethodRule(rule string) (service, method, suffix string, ok bool) {
+	m := methodConfigPattern.FindStringSubmatch(rule)
+	if m == nil {
+		return "", "", "", false
+	}
+	return m[1], m[2], m[3], true
+}
+
+// parseBinaryLogLimits parses "{h:256;m:1024}". Omitting the suffix logs everything, while omitting
+// one of h or m from the braces logs none of it and omitting a value logs all of it, e.g. {h} logs
+// whole headers and no messages.
+func parseBinaryLogLimits(s string) (*binaryLogLimits, error) {
+	parse := func(v string) (uint64, error) {
+		if v == "" {
+			return noLimit, nil
+		}
+		return strconv.ParseUint(v, 10, 64)
+	}
+	var (
+		l   binaryLogLimits
+		err error
+	)
+	if s == "" {
+		return &binaryLogLimits{header: noLimit, message: noLimit}, nil
+	} else if m := headerLimitPattern.FindStringSubmatch(s); m != nil {
+		l.header, err = parse(m[1])
+	} else if m := messageLimitPattern.FindStringSubmatch(s); m != nil {
+		l.message, err = parse(m[1])
+	} else if m := bothLimitsPattern.FindStringSubmatch(s); m != nil {
+		if l.header, err = parse(m[1]); err == nil {
+			l.message, err = parse(m[2])
+		}
+	} else {
+		return nil, fmt.Errorf("invalid header/message limits %q", s)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// limits returns the limits that apply to a method given as /pkg.Service/Method, and false if the
+// method is not logged.
+func (c *binaryLogConfig) limits(name string) (*binaryLogLimits, bool) {
+	name = strings.TrimPrefix(name, "/")
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		return nil, false
+	}
+	if l, found := c.methods[name]; found {
+		return l, true
+	}
+	if c.blacklist[name] {
+		return nil, false
+	}
+	if l, found := c.services[name[:i]]; found {
+		return l, true
+	}
+	return c.all, c.all != nil
+}
+
+// truncate returns the entry as it would have been logged with these limits. Headers and messages
+// that were already truncated when recorded stay marked as such.
+func (l *binaryLogLimits) truncate(e *v1.GrpcLogEntry) *v1.GrpcLogEntry {
+	// trailers are logged whole
+	if e.Payload == nil || e.GetTrailer() != nil {
+		return e
+	}
+	e = proto.Clone(e).(*v1.GrpcLogEntry)
+	var truncated bool
+	switch p := e.Payload.(type) {
+	case *v1.GrpcLogEntry_ClientHeader:
+		truncated = l.truncateMetadata(p.ClientHeader.GetMetadata())
+	case *v1.GrpcLogEntry_ServerHeader:
+		truncated = l.truncateMetadata(p.ServerHeader.GetMetadata())
+	case *v1.GrpcLogEntry_Message:
+		if l.message != noLimit && uint64(len(p.Message.GetData())) > l.message {
+			p.Message.Data = p.Message.Data[:l.message]
+			truncated = true
+		}
+	}
+	e.PayloadTruncated = e.PayloadTruncated || truncated
+	return e
+}
+
+// truncateMetadata keeps the longest prefix of the entries whose keys and values fit the limit.
+// grpc-trace-bin entries in that prefix are kept without being counted.
+func (l *binaryLogLimits) truncateMetadata(md *v1.Metadata) bool {
+	if l.header == noLimit || md == nil {
+		return false
+	}
+	left := l.header
+	var i int
+	for ; i < len(md.Entry); i++ {
+		e := md.Entry[i]
+		if e.Key == "grpc-trace-bin" {
+			continue
+		}
+		n := uint64(len(e.GetKey())) + uint64(len(e.GetValue()))
+		if n > left {
+			break
+		}
+		left -= n
+	}
+	truncated := i < len(md.Entry)
+	md.Entry = md.Entry[:i]
+	return truncated
+}
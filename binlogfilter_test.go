@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+
+	v1 "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+)
+
+func TestBinaryLogConfigLimits(t *testing.T) {
+	tests := []struct {
+		config, method  string
+		logged          bool
+		header, message uint64
+	}{
+		{"*", "/pkg.S/M", true, noLimit, noLimit},
+		{"*{h}", "/pkg.S/M", true, noLimit, 0},
+		{"*{m}", "/pkg.S/M", true, 0, noLimit},
+		{"*{h:256}", "/pkg.S/M", true, 256, 0},
+		{"*{m:1024}", "/pkg.S/M", true, 0, 1024},
+		{"*{h:256;m:1024}", "/pkg.S/M", true, 256, 1024},
+		{"*{h;m}", "/pkg.S/M", true, noLimit, noLimit},
+		{"pkg.S/*", "/pkg.S/M", true, noLimit, noLimit},
+		{"pkg.S/*", "/pkg.T/M", false, 0, 0},
+		{"pkg.S/M{m:1}", "/pkg.S/N", false, 0, 0},
+		// method rules win over blacklist, service and global rules, in that order
+		{"*{h:1},pkg.S/*{h:2},pkg.S/M{h:3}", "/pkg.S/M", true, 3, 0},
+		{"*{h:1},pkg.S/*{h:2},-pkg.S/M", "/pkg.S/M", false, 0, 0},
+		{"*{h:1},pkg.S/*{h:2},-pkg.S/M", "/pkg.S/N", true, 2, 0},
+		{"*{h:1},-pkg.S/M", "/pkg.S/M", false, 0, 0},
+		{"*{h:1},-pkg.S/M", "/pkg.T/M", true, 1, 0},
+	}
+	for _, test := range tests {
+		c, err := parseBinaryLogConfig(test.config)
+		if err != nil {
+			t.Errorf("%q: %v", test.config, err)
+			continue
+		}
+		l, logged := c.limits(test.method)
+		if logged != test.logged {
+			t.Errorf("%q: %s logged = %v, want %v", test.config, test.method, logged, test.logged)
+			continue
+		}
+		if logged && (l.header != test.header || l.message != test.message) {
+			t.Errorf("%q: %s limits = %+v, want header %d message %d", test.config, test.method, *l, test.header, test.message)
+		}
+	}
+}
+
+func TestBinaryLogConfigErrors(t *testing.T) {
+	for _, config := range []string{
+		"",
+		"*,*{h}",
+		"pkg.S/*,pkg.S/*{m}",
+		"pkg.S/M,pkg.S/M{h}",
+		"pkg.S/M,-pkg.S/M",
+		"-pkg.S/M,pkg.S/M",
+		"-pkg.S/*",
+		"-*",
+		"-pkg.S/M{h}",
+		"pkg.S",
+		"*{x}",
+		"*{m;h}",
+		"*{h:-1}",
+	} {
+		if _, err := parseBinaryLogConfig(config); err == nil {
+			t.Errorf("%q: want error", config)
+		}
+	}
+}
+
+func TestBinaryLogLimitsTruncateMetadata(t *testing.T) {
+	md := func(kvs ...string) *v1.Metadata {
+		m := &v1.Metadata{}
+		for i := 0; i < len(kvs); i += 2 {
+			m.Entry = append(m.Entry, &v1.MetadataEntry{Key: kvs[i], Value: []byte(kvs[i+1])})
+		}
+		return m
+	}
+	tests := []struct {
+		header    uint64
+		md        *v1.Metadata
+		keys      []string
+		truncated bool
+	}{
+		{noLimit, md("a", "1", "b", "2"), []string{"a", "b"}, false},
+		{4, md("a", "1", "b", "2"), []string{"a", "b"}, false},
+		{3, md("a", "1", "b", "2"), []string{"a"}, true},
+		// the longest prefix is kept, even if later entries would fit
+		{3, md("a", "1", "long", "value", "b", "2"), []string{"a"}, true},
+		// grpc-trace-bin entries are kept without being counted
+		{2, md("grpc-trace-bin", "trace", "a", "1"), []string{"grpc-trace-bin", "a"}, false},
+		{0, md("grpc-trace-bin", "trace", "a", "1"), []string{"grpc-trace-bin"}, true},
+		{0, md("a", "1", "grpc-trace-bin", "trace"), nil, true},
+	}
+	for i, test := range tests {
+		l := &binaryLogLimits{header: test.header}
+		truncated := l.truncateMetadata(test.md)
+		var keys []string
+		for _, e := range test.md.Entry {
+			keys = append(keys, e.Key)
+		}
+		if truncated != test.truncated || len(keys) != len(test.keys) {
+			t.Errorf("%d: got %q truncated %v, want %q truncated %v", i, keys, truncated, test.keys, test.truncated)
+			continue
+		}
+		for j := range keys {
+			if keys[j] != test.keys[j] {
+				t.Errorf("%d: got %q, want %q", i, keys, test.keys)
+				break
+			}
+		}
+	}
+}
+
+func TestBinaryLogLimitsTruncateMessage(t *testing.T) {
+	l := &binaryLogLimits{header: noLimit, message: 2}
+	e := &v1.GrpcLogEntry{Payload: &v1.GrpcLogEntry_Message{Message: &v1.Message{Length: 3, Data: []byte("abc")}}}
+	got := l.truncate(e)
+	if string(got.GetMessage().GetData()) != "ab" || got.GetMessage().GetLength() != 3 || !got.PayloadTruncated {
+		t.Errorf("got %v, want the first 2 bytes of 3, truncated", got)
+	}
+	if string(e.GetMessage().GetData()) != "abc" {
+		t.Errorf("original entry modified: %v", e)
+	}
+
+	trailer := &v1.GrpcLogEntry{Payload: &v1.GrpcLogEntry_Trailer{Trailer: &v1.Trailer{Metadata: &v1.Metadata{Entry: []*v1.MetadataEntry{{Key: "a", Value: []byte("1")}}}}}}
+	if got := (&binaryLogLimits{}).truncate(trailer); len(got.GetTrailer().GetMetadata().GetEntry()) != 1 || got.PayloadTruncated {
+		t.Errorf("trailer truncated: %v", got)
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+// Descriptors of standard services that show up in most binary logs, so that their
+// messages can be decoded without having to supply their proto files.
+// Importing the generated packages registers their descriptors in the global registry,
+// from where registerServices picks them up.
+import (
+	_ "cloud.google.com/go/longrunning/autogen/longrunningpb"
+	_ "github.com/envoyproxy/go-control-plane/envoy/service/load_stats/v3"
+	_ "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	_ "google.golang.org/grpc/health/grpc_health_v1"
+	_ "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	_ "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+)
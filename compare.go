@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// compareTable compares the statistics of each group against the baseline and returns
+// the number of groups whose regressions exceed the configured thresholds.
+func (cmd *StatsCmd) compareTable(baseline, current []*groupSummary) (*table, int) {
+	type pair struct {
+		values   []string
+		old, cur *groupSummary
+	}
+	var pairs []*pair
+	byKey := map[string]*pair{}
+	lookup := func(values []string) *pair {
+		key := strings.Join(values, "\x00")
+		p, found := byKey[key]
+		if !found {
+			p = &pair{values: values}
+			byKey[key] = p
+			pairs = append(pairs, p)
+		}
+		return p
+	}
+	for _, g := range current {
+		lookup(g.values).cur = g
+	}
+	for _, g := range baseline {
+		lookup(g.values).old = g
+	}
+	// groups are ordered by their current statistics, or by their baseline ones once gone
+	less := cmd.lessSummaries()
+	summary := func(p *pair) *groupSummary {
+		if p.cur != nil {
+			return p.cur
+		}
+		return p.old
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return less(summary(pairs[i]), summary(pairs[j])) })
+
+	header := append(cmd.groupColumns(), "Calls", "Calls Δ", "Error rate", "Error rate Δ", "p50", "p50 Δ", "p99", "p99 Δ", "Significant", "Verdict")
+	t := &table{header: header}
+	var failures int
+	for _, p := range pairs {
+		row := append([]string{}, p.values...)
+		if p.old == nil || p.cur == nil {
+			var side string
+			var g *groupSummary
+			if p.old == nil {
+				side, g = "new", p.cur
+			} else {
+				side, g = "gone", p.old
+			}
+			row = append(row, fmt.Sprint(g.calls.calls), side, fmt.Sprintf("%.2f%%", g.calls.errorRate()*100), "", fmt.Sprint(g.calls.percentile(0.5)), "", fmt.Sprint(g.calls.percentile(0.99)), "", "", "")
+			t.append(row...)
+			continue
+		}
+		o, c := &p.old.calls, &p.cur.calls
+
+		var significant []string
+		if errorRateIncreasePValue(o, c) < cmd.Significance {
+			significant = append(significant, "error-rate")
+		}
+		if latencyIncreasePValue(o.latencies, c.latencies) < cmd.Significance {
+			significant = append(significant, "latency")
+		}
+
+		errorRateDelta := (c.errorRate() - o.errorRate()) * 100
+		p99Delta := relativeDelta(float64(o.percentile(0.99)), float64(c.percentile(0.99)))
+		var verdict []string
+		if cmd.MaxErrorRateIncrease > 0 && errorRateDelta > cmd.MaxErrorRateIncrease {
+			verdict = append(verdict, "error-rate")
+		}
+		if cmd.MaxP99Increase > 0 && p99Delta > cmd.MaxP99Increase {
+			verdict = append(verdict, "p99")
+		}
+		if len(verdict) > 0 {
+			failures++
+		}
+
+		row = append(row,
+			fmt.Sprint(c.calls),
+			formatDelta(relativeDelta(float64(o.calls), float64(c.calls)), "%"),
+			fmt.Sprintf("%.2f%%", c.errorRate()*100),
+			formatDelta(errorRateDelta, "pp"),
+			fmt.Sprint(c.percentile(0.5)),
+			formatDelta(relativeDelta(float64(o.percentile(0.5)), float64(c.percentile(0.5))), "%"),
+			fmt.Sprint(c.percentile(0.99)),
+			formatDelta(p99Delta, "%"),
+			strings.Join(significant, ","),
+			verdictString(verdict),
+		)
+		t.append(row...)
+	}
+	return t, failures
+}
+
+func verdictString(exceeded []string) string {
+	if len(exceeded) == 0 {
+		return "ok"
+	}
+	return "REGRESSION (" + strings.Join(exceeded, ",") + ")"
+}
+
+// relativeDelta returns the change from old to cur, in percent.
+func relativeDelta(old, cur float64) float64 {
+	if old == 0 {
+		if cur == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return (cur - old) / old * 100
+}
+
+func formatDelta(d float64, unit string) string {
+	return fmt.Sprintf("%+.1f%s", d, unit)
+}
+
+// errorRateIncreasePValue returns the p-value of a one-sided two-proportion z-test
+// for the hypothesis that the error rate of cur is higher than the error rate of old.
+func errorRateIncreasePValue(old, cur *callStats) float64 {
+	n1, n2 := float64(old.completed), float64(cur.completed)
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	pooled := float64(old.errors+cur.errors) / (n1 + n2)
+	se := math.Sqrt(pooled * (1 - pooled) * (1/n1 + 1/n2))
+	if se == 0 {
+		return 1
+	}
+	z := (cur.errorRate() - old.errorRate()) / se
+	return normalSurvival(z)
+}
+
+// latencyIncreasePValue returns the p-value of a one-sided Mann-Whitney U test
+// (normal approximation) for the hypothesis that cur latencies tend to be higher than old ones.
+func latencyIncreasePValue(old, cur []time.Duration) float64 {
+	n1, n2 := len(old), len(cur)
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	type sample struct {
+		d   time.Duration
+		cur bool
+	}
+	var all []sample
+	for _, d := range old {
+		all = append(all, sample{d, false})
+	}
+	for _, d := range cur {
+		all = append(all, sample{d, true})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].d < all[j].d })
+
+	// sum of the ranks of cur samples, assigning tied values their average rank.
+	var rankSum float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].d == all[i].d {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].cur {
+				rankSum += rank
+			}
+		}
+		i = j
+	}
+	u := rankSum - float64(n2*(n2+1))/2
+	mean := float64(n1*n2) / 2
+	sd := math.Sqrt(float64(n1*n2*(n1+n2+1)) / 12)
+	if sd == 0 {
+		return 1
+	}
+	return normalSurvival((u - mean) / sd)
+}
+
+// normalSurvival returns P(Z > z) for a standard normal Z.
+func normalSurvival(z float64) float64 {
+	return 0.5 * math.Erfc(z/math.Sqrt2)
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+type CompatCmd struct {
+	CmdCommon
+
+	Old    string `required:"" type:"existingfile" help:"Descriptor set (or Buf image) the traffic was recorded with"`
+	New    string `required:"" type:"existingfile" help:"Descriptor set (or Buf image) to check the recorded traffic against"`
+	Output string `optional:"" enum:"table,csv,json" default:"table" help:"Output format (table, csv, json)"`
+}
+
+// A schema is a set of descriptors kept apart from the registered ones, so that two versions
+// of the same files can be loaded side by side.
+type schema struct {
+	files *protoregistry.Files
+	types *dynamicpb.Types
+}
+
+// loadSchema reads a descriptor set. Dependencies missing from the set are taken from the
+// registered files, e.g. the well-known types.
+func loadSchema(filename string) (*schema, error) {
+	fdset, err := readFileDescriptorSet(filename)
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]int{}
+	for i, fdp := range fdset.GetFile() {
+		byName[fdp.GetName()] = i
+	}
+
+	files := new(protoregistry.Files)
+	var visit func(name string) error
+	visit = func(name string) error {
+		if _, err := files.FindFileByPath(name); err == nil {
+			return nil
+		}
+		i, found := byName[name]
+		if !found {
+			fd, err := protoregistry.GlobalFiles.FindFileByPath(name)
+			if err != nil {
+				return fmt.Errorf("file %s not found", name)
+			}
+			return files.RegisterFile(fd)
+		}
+		fdp := fdset.GetFile()[i]
+		for _, dep := range fdp.GetDependency() {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		fd, err := protodesc.NewFile(fdp, files)
+		if err != nil {
+			return fmt.Errorf("file %s: %w", name, err)
+		}
+		return files.RegisterFile(fd)
+	}
+	for _, fdp := range fdset.GetFile() {
+		if err := visit(fdp.GetName()); err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+	return &schema{files: files, types: dynamicpb.NewTypes(files)}, nil
+}
+
+// messageTypes returns the request and response types of a method given as /pkg.Service/Method.
+func (s *schema) messageTypes(method string) (protoreflect.MessageDescriptor, protoreflect.MessageDescriptor, error) {
+	i := strings.LastIndex(method, "/")
+	if i < 0 {
+		return nil, nil, fmt.Errorf("invalid method name %q", method)
+	}
+	desc, err := s.files.FindDescriptorByName(protoreflect.FullName(strings.TrimPrefix(method[:i], "/")))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot find service for %q: %w", method, err)
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s is not a service", desc.FullName())
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method[i+1:]))
+	if md == nil {
+		return nil, nil, fmt.Errorf("cannot find method %q", method)
+	}
+	return md.Input(), md.Output(), nil
+}
+
+// decode decodes a payload, or as much of it as was captured if it was truncated by the logger.
+func (s *schema) decode(raw []byte, md protoreflect.MessageDescriptor, truncated bool) (protoreflect.Message, error) {
+	msg := dynamicpb.NewMessage(md)
+	if truncated {
+		if _, err := partialUnmarshal(raw, msg, "", s.types); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+	if err := (proto.UnmarshalOptions{Resolver: s.types}).Unmarshal(raw, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// A compatChange is a difference in how a field is decoded by the old and the new schema.
+type compatChange struct {
+	method, direction, path, change string
+}
+
+func (cmd *CompatCmd) Run(cli *Context) error {
+	oldSchema, err := loadSchema(cmd.Old)
+	if err != nil {
+		return err
+	}
+	newSchema, err := loadSchema(cmd.New)
+	if err != nil {
+		return err
+	}
+
+	f, err := openFile(cmd.LogInputFile, cli.Follow)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	conversations, err := readConversations(cli, f)
+	if err != nil {
+		return err
+	}
+
+	counts := map[compatChange]int{}
+	var total, changed int
+	check := func(method, direction string, oldType, newType protoreflect.MessageDescriptor, entries []*v1.GrpcLogEntry) {
+		for _, e := range entries {
+			total++
+			// each change is counted once per message
+			seen := map[compatChange]bool{}
+			report := func(path, change string) {
+				c := compatChange{method: method, direction: direction, path: path, change: change}
+				if !seen[c] {
+					seen[c] = true
+					counts[c]++
+				}
+			}
+			raw := e.GetMessage().GetData()
+			if _, err := newSchema.decode(raw, newType, e.PayloadTruncated); err != nil {
+				report("", "cannot decode with the new schema: "+err.Error())
+			} else if old, err := oldSchema.decode(raw, oldType, e.PayloadTruncated); err != nil {
+				report("", "cannot decode with the old schema: "+err.Error())
+			} else {
+				diffMessage(report, "", old, newType, newSchema.types)
+			}
+			if len(seen) > 0 {
+				changed++
+			}
+		}
+	}
+	for _, c := range conversations {
+		if c.CallId() == 0 {
+			continue
+		}
+		method := c.MethodName()
+		oldReq, oldResp, err := oldSchema.messageTypes(method)
+		if err != nil {
+			// e.g. health checks, nothing to compare against
+			counts[compatChange{method: method, change: "not in old schema"}] += len(c.requestMessages) + len(c.responseMessages)
+			continue
+		}
+		newReq, newResp, err := newSchema.messageTypes(method)
+		if err != nil {
+			total += len(c.requestMessages) + len(c.responseMessages)
+			changed += len(c.requestMessages) + len(c.responseMessages)
+			counts[compatChange{method: method, change: "method removed"}] += len(c.requestMessages) + len(c.responseMessages)
+			continue
+		}
+		check(method, "request", oldReq, newReq, c.requestMessages)
+		check(method, "response", oldResp, newResp, c.responseMessages)
+	}
+
+	changes := make([]compatChange, 0, len(counts))
+	for c := range counts {
+		changes = append(changes, c)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		if a.direction != b.direction {
+			return a.direction < b.direction
+		}
+		if a.path != b.path {
+			return a.path < b.path
+		}
+		return a.change < b.change
+	})
+	t := &table{header: []string{"Method", "Direction", "Field", "Change", "Messages"}}
+	for _, c := range changes {
+		t.append(c.method, c.direction, c.path, c.change, strconv.Itoa(counts[c]))
+	}
+	if err := t.write(os.Stdout, cmd.Output); err != nil {
+		return err
+	}
+	// methods missing from the old schema are listed but don't count as changes
+	if changed > 0 {
+		return fmt.Errorf("%d of %d messages change meaning with the new schema", changed, total)
+	}
+	return nil
+}
+
+// diffMessage reports the fields set in old, decoded with the old schema, which the new message
+// type would decode differently. Fields only known to the new schema are not reported.
+func diffMessage(report func(path, change string), prefix string, old protoreflect.Message, newType protoreflect.MessageDescriptor, newTypes *dynamicpb.Types) {
+	old.Range(func(ofd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if ofd.IsExtension() {
+			path := fmt.Sprintf("%s[%s]", prefix, ofd.FullName())
+			xt, err := newTypes.FindExtensionByNumber(newType.FullName(), ofd.Number())
+			if err != nil {
+				report(path, "dropped")
+				return true
+			}
+			diffField(report, path, ofd, xt.TypeDescriptor(), v, newTypes)
+			return true
+		}
+		path := prefix + string(ofd.Name())
+		nfd := newType.Fields().ByNumber(ofd.Number())
+		if nfd == nil {
+			report(path, "dropped")
+			return true
+		}
+		if ofd.Name() != nfd.Name() {
+			report(path, "renamed to "+string(nfd.Name()))
+		}
+		diffField(report, path, ofd, nfd, v, newTypes)
+		return true
+	})
+}
+
+func diffField(report func(path, change string), path string, ofd, nfd protoreflect.FieldDescriptor, v protoreflect.Value, newTypes *dynamicpb.Types) {
+	if ofd.IsMap() != nfd.IsMap() || ofd.IsList() != nfd.IsList() {
+		report(path, fmt.Sprintf("type changed from %s to %s", fieldType(ofd), fieldType(nfd)))
+		return
+	}
+	switch {
+	case ofd.IsMap():
+		if ofd.MapKey().Kind() != nfd.MapKey().Kind() {
+			report(path, fmt.Sprintf("type changed from %s to %s", fieldType(ofd), fieldType(nfd)))
+			return
+		}
+		v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+			diffValue(report, path+"[]", ofd.MapValue(), nfd.MapValue(), mv, newTypes)
+			return true
+		})
+	case ofd.IsList():
+		l := v.List()
+		for i := 0; i < l.Len(); i++ {
+			diffValue(report, path+"[]", ofd, nfd, l.Get(i), newTypes)
+		}
+	default:
+		diffValue(report, path, ofd, nfd, v, newTypes)
+	}
+}
+
+func diffValue(report func(path, change string), path string, ofd, nfd protoreflect.FieldDescriptor, v protoreflect.Value, newTypes *dynamicpb.Types) {
+	if ofd.Kind() != nfd.Kind() {
+		report(path, fmt.Sprintf("type changed from %s to %s", valueType(ofd), valueType(nfd)))
+		return
+	}
+	switch ofd.Kind() {
+	case protoreflect.EnumKind:
+		if nfd.Enum().Values().ByNumber(v.Enum()) == nil {
+			name := strconv.Itoa(int(v.Enum()))
+			if ev := ofd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+				name = string(ev.Name())
+			}
+			report(path, fmt.Sprintf("enum value %s unknown to %s", name, nfd.Enum().FullName()))
+		}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		diffMessage(report, path+".", v.Message(), nfd.Message(), newTypes)
+	}
+}
+
+// fieldType describes the type of a field, e.g. "repeated int32" or "map<string, pkg.Value>".
+func fieldType(fd protoreflect.FieldDescriptor) string {
+	switch {
+	case fd.IsMap():
+		return fmt.Sprintf("map<%s, %s>", valueType(fd.MapKey()), valueType(fd.MapValue()))
+	case fd.IsList():
+		return "repeated " + valueType(fd)
+	default:
+		return valueType(fd)
+	}
+}
+
+func valueType(fd protoreflect.FieldDescriptor) string {
+	switch fd.Kind() {
+	case protoreflect.EnumKind:
+		return string(fd.Enum().FullName())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return string(fd.Message().FullName())
+	default:
+		return fd.Kind().String()
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+type ConcurrencyCmd struct {
+	CmdCommon
+
+	Window time.Duration `optional:"" help:"Report in-flight calls over fixed windows of this duration (e.g. 1m) instead of over the whole log"`
+	CallID uint64        `optional:"" help:"List the calls that overlapped with the call with this id"`
+	Output string        `optional:"" enum:"table,csv" default:"table" help:"Output format (table, csv)"`
+}
+
+// An interval is the time span during which a call was in flight.
+type interval struct {
+	conversation
+	start, end time.Time
+}
+
+func (cmd *ConcurrencyCmd) Run(cli *Context) error {
+	f, err := openFile(cmd.LogInputFile, cli.Follow)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	conversations, err := readConversations(cli, f)
+	if err != nil {
+		return err
+	}
+	intervals := callIntervals(conversations)
+	if len(intervals) == 0 {
+		return fmt.Errorf("no calls found")
+	}
+
+	var t *table
+	if cmd.CallID != 0 {
+		t, err = cmd.overlapTable(intervals)
+		if err != nil {
+			return err
+		}
+	} else {
+		t = cmd.concurrencyTable(intervals)
+	}
+	return t.write(os.Stdout, cmd.Output)
+}
+
+// callIntervals returns the in-flight intervals of the calls, ordered by start time.
+// Calls that never completed are considered in flight until the last event in the log.
+func callIntervals(conversations []conversation) []interval {
+	var last time.Time
+	for _, c := range conversations {
+		for _, e := range c.entries() {
+			if t := e.GetTimestamp().AsTime(); t.After(last) {
+				last = t
+			}
+		}
+	}
+
+	var res []interval
+	for _, c := range conversations {
+		// skip conversations that have no client headers
+		if c.CallId() == 0 {
+			continue
+		}
+		i := interval{conversation: c, start: c.requestHeader.GetTimestamp().AsTime(), end: last}
+		if c.Completed() {
+			i.end = c.responseTrailer.GetTimestamp().AsTime()
+		}
+		res = append(res, i)
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].start.Before(res[j].start) })
+	return res
+}
+
+type concurrency struct {
+	start   time.Time
+	peak    int
+	average float64
+}
+
+// inFlight computes the peak and average number of in-flight calls over consecutive windows
+// starting at from and covering all the intervals. If window is zero a single window is used.
+func inFlight(intervals []interval, from, to time.Time, window time.Duration) []concurrency {
+	type event struct {
+		t     time.Time
+		delta int
+	}
+	var events []event
+	for _, i := range intervals {
+		events = append(events, event{i.start, 1}, event{i.end, -1})
+	}
+	// ends sort before starts happening at the same time, so that back to back calls don't overlap.
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].t.Equal(events[j].t) {
+			return events[i].t.Before(events[j].t)
+		}
+		return events[i].delta < events[j].delta
+	})
+
+	if window == 0 {
+		window = to.Sub(from) + 1
+	}
+	var (
+		res     []concurrency
+		running int
+		cur     = concurrency{start: from}
+		end     = from.Add(window)
+		last    = from
+		area    float64
+	)
+	flush := func() {
+		area += float64(running) * float64(end.Sub(last))
+		cur.average = area / float64(window)
+		res = append(res, cur)
+		cur = concurrency{start: end, peak: running}
+		last, end, area = end, end.Add(window), 0
+	}
+	for _, e := range events {
+		for !e.t.Before(end) {
+			flush()
+		}
+		area += float64(running) * float64(e.t.Sub(last))
+		last = e.t
+		running += e.delta
+		if running > cur.peak {
+			cur.peak = running
+		}
+	}
+	// keep going until to, so that series of calls ending at different times have the same windows
+	for !to.Before(end) {
+		flush()
+	}
+	flush()
+	return res
+}
+
+func (cmd *ConcurrencyCmd) concurrencyTable(intervals []interval) *table {
+	from, to := intervals[0].start, intervals[0].end
+	for _, i := range intervals {
+		if i.end.After(to) {
+			to = i.end
+		}
+	}
+	if cmd.Window > 0 {
+		from = from.Truncate(cmd.Window)
+	}
+
+	byMethod := map[string][]interval{}
+	var methods []string
+	for _, i := range intervals {
+		m := i.MethodName()
+		if _, found := byMethod[m]; !found {
+			methods = append(methods, m)
+		}
+		byMethod[m] = append(byMethod[m], i)
+	}
+	sort.Strings(methods)
+
+	type series struct {
+		method string
+		values []concurrency
+	}
+	all := []series{{"(all)", inFlight(intervals, from, to, cmd.Window)}}
+	for _, m := range methods {
+		all = append(all, series{m, inFlight(byMethod[m], from, to, cmd.Window)})
+	}
+
+	if cmd.Window == 0 {
+		t := &table{header: []string{"Method", "Calls", "Peak", "Average"}}
+		for _, s := range all {
+			calls := len(intervals)
+			if s.method != "(all)" {
+				calls = len(byMethod[s.method])
+			}
+			t.append(s.method, fmt.Sprint(calls), fmt.Sprint(s.values[0].peak), fmt.Sprintf("%.2f", s.values[0].average))
+		}
+		return t
+	}
+
+	t := &table{header: []string{"Window", "Method", "Peak", "Average"}}
+	for w := range all[0].values {
+		for _, s := range all {
+			c := s.values[w]
+			t.append(c.start.Format("2006/01/02 15:04:05"), s.method, fmt.Sprint(c.peak), fmt.Sprintf("%.2f", c.average))
+		}
+	}
+	return t
+}
+
+// overlapTable lists the calls that were in flight at the same time as the call selected with --call-id.
+func (cmd *ConcurrencyCmd) overlapTable(intervals []interval) (*table, error) {
+	var target *interval
+	for i := range intervals {
+		if intervals[i].CallId() == cmd.CallID {
+			target = &intervals[i]
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("cannot find call with id %d", cmd.CallID)
+	}
+
+	t := &table{header: []string{"ID", "When", "Elapsed", "Method", "Status", "Overlap", "Started"}}
+	for _, i := range intervals {
+		if i.CallId() == target.CallId() || !i.start.Before(target.end) || !i.end.After(target.start) {
+			continue
+		}
+		start, end := i.start, i.end
+		if target.start.After(start) {
+			start = target.start
+		}
+		if target.end.Before(end) {
+			end = target.end
+		}
+		started := "during"
+		if i.start.Before(target.start) {
+			started = "before"
+		}
+		t.append(fmt.Sprint(i.CallId()), i.Timestamp(), i.Elapsed(), i.MethodName(), i.StatusCode().String(), fmt.Sprint(end.Sub(start)), started)
+	}
+	return t, nil
+}
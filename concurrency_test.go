@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	v1 "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+)
+
+func testInterval(method string, start time.Time, elapsed time.Duration) interval {
+	header := &v1.GrpcLogEntry{
+		CallId:  1,
+		Type:    v1.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER,
+		Payload: &v1.GrpcLogEntry_ClientHeader{ClientHeader: &v1.ClientHeader{MethodName: method}},
+	}
+	return interval{conversation: conversation{requestHeader: header}, start: start, end: start.Add(elapsed)}
+}
+
+func TestConcurrencyTableMethodsEndingAtDifferentTimes(t *testing.T) {
+	start := time.Date(2022, 1, 26, 12, 0, 0, 0, time.UTC)
+	intervals := []interval{
+		testInterval("/a.B/X", start, time.Second),
+		testInterval("/a.B/Y", start, 5*time.Minute),
+	}
+
+	cmd := &ConcurrencyCmd{Window: time.Minute}
+	tab := cmd.concurrencyTable(intervals)
+	// windows 12:00 to 12:05 included, for (all), /a.B/X and /a.B/Y
+	if got, want := len(tab.rows), 6*3; got != want {
+		t.Fatalf("got %d rows, want %d", got, want)
+	}
+	for w := 1; w < 6; w++ {
+		if r := tab.rows[3*w+1]; r[1] != "/a.B/X" || r[2] != "0" {
+			t.Errorf("row %v: want /a.B/X with no call in flight", r)
+		}
+	}
+}
+
+func TestInFlightWindows(t *testing.T) {
+	start := time.Date(2022, 1, 26, 12, 0, 0, 0, time.UTC)
+	short := []interval{testInterval("/a.B/X", start, time.Second)}
+	to := start.Add(5 * time.Minute)
+
+	got := inFlight(short, start, to, time.Minute)
+	if len(got) != 6 {
+		t.Fatalf("got %d windows, want 6", len(got))
+	}
+	if got[0].peak != 1 {
+		t.Errorf("first window peak = %d, want 1", got[0].peak)
+	}
+	for _, c := range got[1:] {
+		if c.peak != 0 || c.average != 0 {
+			t.Errorf("window %v: got peak %d average %v, want 0", c.start, c.peak, c.average)
+		}
+	}
+}
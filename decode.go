@@ -47,24 +47,38 @@ func (cmd *DecodeCmd) Run(cli *Context) error {
 		if err != nil {
 			return fmt.Errorf("cannot marshal dynamic proto: %w", err)
 		}
-		if cmd.Expand && e.Type == v1.GrpcLogEntry_EVENT_TYPE_CLIENT_MESSAGE {
+		// truncated payloads are kept as is, encoding a partially decoded message would lose the captured bytes.
+		if cmd.Expand && e.Type == v1.GrpcLogEntry_EVENT_TYPE_CLIENT_MESSAGE && !e.PayloadTruncated {
 			var unstructured map[string]any
 			if err := json.Unmarshal(res, &unstructured); err != nil {
 				return err
 			}
 			if true {
-				delete(unstructured["message"].(map[string]any), "data")
-
 				msgType, err := conv.RequestMessageType(cli)
 				if err != nil {
 					return err
 				}
-				decoded, err := formatEntry(e, msgType)
+				msg, err := parseBody(e.GetMessage().GetData(), msgType)
+				if err != nil {
+					return fmt.Errorf("decoding as %q: %w", msgType, err)
+				}
+				decoded, err := marshalMessage(msg)
 				if err != nil {
 					return fmt.Errorf("decoding as %q: %w", msgType, err)
 				}
 
 				unstructured["message"].(map[string]any)["decoded"] = json.RawMessage(decoded)
+				// fields unknown to the descriptor are lost in the decoded form: list them and keep the
+				// original data, from which encode restores them.
+				if unknown := unknownFields(msg.ProtoReflect()); len(unknown) > 0 {
+					var fields []string
+					for _, f := range unknown {
+						fields = append(fields, f.String())
+					}
+					unstructured["message"].(map[string]any)["unknownFields"] = fields
+				} else {
+					delete(unstructured["message"].(map[string]any), "data")
+				}
 			}
 			res, err = json.MarshalIndent(unstructured, "", "  ")
 			if err != nil {
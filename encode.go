@@ -60,9 +60,26 @@ func (cmd *EncodeCmd) Run(cli *Context) error {
 					return err
 				}
 				delete(msg, "decoded")
-				if err := protojson.Unmarshal([]byte(decoded), dataMessage); err != nil {
+				if err := (protojson.UnmarshalOptions{Resolver: registeredTypes()}).Unmarshal([]byte(decoded), dataMessage); err != nil {
 					return err
 				}
+				// unknown fields are restored from the original data, the list is only informative.
+				if _, hasUnknown := msg["unknownFields"]; hasUnknown {
+					data, hasData := msg["data"].(string)
+					if !hasData {
+						return fmt.Errorf("call ID %d: message with unknown fields lacks its original data, the unknown fields would be lost", callId)
+					}
+					raw, err := base64.StdEncoding.DecodeString(data)
+					if err != nil {
+						return err
+					}
+					original, err := parseBody(raw, messageType)
+					if err != nil {
+						return err
+					}
+					copyUnknownFields(dataMessage.ProtoReflect(), original.ProtoReflect())
+					delete(msg, "unknownFields")
+				}
 				encoded, err := proto.Marshal(dataMessage)
 				if err != nil {
 					return err
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/grpc/codes"
+	"mkm.pub/binlog/reader"
+)
+
+type ExporterCmd struct {
+	Path   string        `arg:"" name:"path" help:"Binary log file, or directory containing binary log files, to follow"`
+	Listen string        `optional:"" default:":9100" help:"listen address of the HTTP metrics server"`
+	Rescan time.Duration `optional:"" default:"30s" help:"How often to look for new files when following a directory"`
+	// calls whose end is never logged, e.g. in logs cut mid-call, would otherwise be tracked forever
+	IncompleteAfter time.Duration `optional:"" default:"10m" help:"Stop waiting for the end of calls that started this long ago and count them as incomplete"`
+}
+
+var (
+	durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.2, 0.5, 1, 2.5, 5, 10, 100}
+	sizeBuckets     = []float64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304}
+)
+
+func (cmd *ExporterCmd) Run(cli *Context) error {
+	ctx := context.Background()
+	m := newMetrics()
+
+	st, err := os.Stat(cmd.Path)
+	if err != nil {
+		return err
+	}
+	if st.IsDir() {
+		go cmd.followDir(ctx, m)
+	} else {
+		go cmd.followFile(ctx, m, cmd.Path)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := m.write(w); err != nil {
+			log.Printf("writing metrics: %v", err)
+		}
+	})
+	log.Printf("Serving metrics at %q", cmd.Listen)
+	return http.ListenAndServe(cmd.Listen, mux)
+}
+
+// followDir follows every file in the directory, periodically looking for new files.
+func (cmd *ExporterCmd) followDir(ctx context.Context, m *metrics) {
+	following := map[string]bool{}
+	for {
+		entries, err := os.ReadDir(cmd.Path)
+		if err != nil {
+			log.Printf("reading %s: %v", cmd.Path, err)
+		}
+		for _, e := range entries {
+			name := filepath.Join(cmd.Path, e.Name())
+			if e.IsDir() || following[name] {
+				continue
+			}
+			following[name] = true
+			go cmd.followFile(ctx, m, name)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cmd.Rescan):
+		}
+	}
+}
+
+// followFile reassembles conversations as entries are appended to the file and records
+// metrics for each conversation once it's complete.
+func (cmd *ExporterCmd) followFile(ctx context.Context, m *metrics, filename string) {
+	log.Printf("following %s", filename)
+	entries, errCh := reader.ReadFile(ctx, filename, true)
+
+	conversations := map[uint64]*conversation{}
+	// time of the latest entry, which advances with the wall clock while no entries are appended
+	var latest, lastRead, lastEvicted time.Time
+	evict := func(now time.Time) {
+		for id, c := range conversations {
+			if e := c.entries(); len(e) == 0 || now.Sub(e[0].GetTimestamp().AsTime()) >= cmd.IncompleteAfter {
+				m.recordIncomplete(c)
+				delete(conversations, id)
+			}
+		}
+		lastEvicted = now
+	}
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		var e *v1.GrpcLogEntry
+		select {
+		case e = <-entries:
+		case <-ticker.C:
+			if now := latest.Add(time.Since(lastRead)); !latest.IsZero() && now.Sub(lastEvicted) >= time.Minute {
+				evict(now)
+			}
+			continue
+		}
+		if e == nil {
+			break
+		}
+		if t := e.GetTimestamp().AsTime(); t.After(latest) {
+			latest = t
+		}
+		lastRead = time.Now()
+
+		c, found := conversations[e.CallId]
+		if !found {
+			c = &conversation{}
+			conversations[e.CallId] = c
+		}
+		c.Record(e)
+
+		switch e.Type {
+		case v1.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER:
+			m.recordStarted(c)
+		case v1.GrpcLogEntry_EVENT_TYPE_SERVER_TRAILER:
+			m.recordHandled(c, c.StatusCode())
+			delete(conversations, e.CallId)
+		case v1.GrpcLogEntry_EVENT_TYPE_CANCEL:
+			m.recordHandled(c, codes.Canceled)
+			delete(conversations, e.CallId)
+		}
+		if latest.Sub(lastEvicted) >= time.Minute {
+			evict(latest)
+		}
+	}
+	if err := <-errCh; err != nil {
+		log.Printf("following %s: %v", filename, err)
+	}
+}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+type methodCode struct {
+	method string
+	code   codes.Code
+}
+
+// metrics holds the metrics exported in the Prometheus text exposition format.
+type metrics struct {
+	mu sync.Mutex
+
+	started       map[string]uint64
+	handled       map[methodCode]uint64
+	incomplete    map[string]uint64
+	durations     map[string]*histogram
+	requestSizes  map[string]*histogram
+	responseSizes map[string]*histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		started:       map[string]uint64{},
+		handled:       map[methodCode]uint64{},
+		incomplete:    map[string]uint64{},
+		durations:     map[string]*histogram{},
+		requestSizes:  map[string]*histogram{},
+		responseSizes: map[string]*histogram{},
+	}
+}
+
+func (m *metrics) recordStarted(c *conversation) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.started[c.MethodName()]++
+}
+
+// recordIncomplete records a call whose end was not logged in time.
+func (m *metrics) recordIncomplete(c *conversation) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.incomplete[c.MethodName()]++
+}
+
+func (m *metrics) recordHandled(c *conversation, code codes.Code) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	method := c.MethodName()
+	m.handled[methodCode{method, code}]++
+
+	if c.requestHeader != nil && c.Completed() {
+		observe(m.durations, method, durationBuckets, c.ElapsedDuration().Seconds())
+	}
+	for _, e := range c.requestMessages {
+		observe(m.requestSizes, method, sizeBuckets, float64(e.GetMessage().GetLength()))
+	}
+	for _, e := range c.responseMessages {
+		observe(m.responseSizes, method, sizeBuckets, float64(e.GetMessage().GetLength()))
+	}
+}
+
+func observe(hs map[string]*histogram, method string, buckets []float64, v float64) {
+	h, found := hs[method]
+	if !found {
+		h = newHistogram(buckets)
+		hs[method] = h
+	}
+	h.observe(v)
+}
+
+func (m *metrics) write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# HELP grpc_binlog_started_total Total number of RPCs started.\n")
+	fmt.Fprintf(&b, "# TYPE grpc_binlog_started_total counter\n")
+	for _, method := range sortedKeys(m.started) {
+		fmt.Fprintf(&b, "grpc_binlog_started_total{grpc_method=%q} %d\n", method, m.started[method])
+	}
+
+	fmt.Fprintf(&b, "# HELP grpc_binlog_handled_total Total number of RPCs completed, by status code.\n")
+	fmt.Fprintf(&b, "# TYPE grpc_binlog_handled_total counter\n")
+	var handled []methodCode
+	for k := range m.handled {
+		handled = append(handled, k)
+	}
+	sort.Slice(handled, func(i, j int) bool {
+		if handled[i].method != handled[j].method {
+			return handled[i].method < handled[j].method
+		}
+		return handled[i].code < handled[j].code
+	})
+	for _, k := range handled {
+		fmt.Fprintf(&b, "grpc_binlog_handled_total{grpc_method=%q,grpc_code=%q} %d\n", k.method, k.code, m.handled[k])
+	}
+
+	fmt.Fprintf(&b, "# HELP grpc_binlog_incomplete_total Total number of RPCs whose end was not logged.\n")
+	fmt.Fprintf(&b, "# TYPE grpc_binlog_incomplete_total counter\n")
+	for _, method := range sortedKeys(m.incomplete) {
+		fmt.Fprintf(&b, "grpc_binlog_incomplete_total{grpc_method=%q} %d\n", method, m.incomplete[method])
+	}
+
+	writeHistograms(&b, "grpc_binlog_handling_seconds", "Histogram of RPC latency, from client header to trailer.", m.durations)
+	writeHistograms(&b, "grpc_binlog_request_message_bytes", "Histogram of request message sizes.", m.requestSizes)
+	writeHistograms(&b, "grpc_binlog_response_message_bytes", "Histogram of response message sizes.", m.responseSizes)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeHistograms(w io.Writer, name, help string, hs map[string]*histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for _, method := range sortedKeys(hs) {
+		h := hs[method]
+		for i, le := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{grpc_method=%q,le=\"%g\"} %d\n", name, method, le, h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{grpc_method=%q,le=\"+Inf\"} %d\n", name, method, h.count)
+		fmt.Fprintf(w, "%s_sum{grpc_method=%q} %g\n", name, method, h.sum)
+		fmt.Fprintf(w, "%s_count{grpc_method=%q} %d\n", name, method, h.count)
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
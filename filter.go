@@ -3,8 +3,15 @@ package main
 import (
 	"context"
 	"encoding/binary"
+	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"strings"
+	"time"
 
+	v1 "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/protobuf/proto"
 	"mkm.pub/binlog/reader"
 )
@@ -12,7 +19,101 @@ import (
 type FilterCmd struct {
 	CmdCommon
 
-	CallID uint64 `optional:""`
+	CallID      uint64        `optional:"" help:"Keep the call with this id"`
+	Methods     []string      `optional:"" name:"method" sep:"none" help:"Keep calls to methods matching this glob, e.g. /pkg.Svc/*"`
+	MethodRegex string        `optional:"" help:"Keep calls to methods matching this regular expression"`
+	Since       time.Time     `optional:"" help:"Keep calls started at or after this time (RFC 3339)"`
+	Until       time.Time     `optional:"" help:"Keep calls started before this time (RFC 3339)"`
+	Status      []string      `optional:"" help:"Keep calls that completed with one of these status codes, e.g. Unavailable,DeadlineExceeded"`
+	MinElapsed  time.Duration `optional:"" help:"Keep calls that took at least this long"`
+	MaxElapsed  time.Duration `optional:"" help:"Keep calls that took at most this long"`
+	Peers       []string      `optional:"" name:"peer" sep:"none" help:"Keep calls from or to peers matching this glob, e.g. 10.0.0.*"`
+	Metadata    []string      `optional:"" sep:"none" help:"Keep calls with a request metadata value matching key=glob; all must match when repeated"`
+	EventTypes  []string      `optional:"" name:"event-type" enum:"client-header,server-header,client-message,server-message,client-half-close,server-trailer,cancel" help:"Only write entries of these event types of the kept calls"`
+	LogConfig   string        `optional:"" name:"binary-log-filter" help:"Keep and truncate calls as gRPC would when logging with this GRPC_BINARY_LOG_FILTER value, e.g. \"*{h:256;m:1024},-pkg.Svc/Method\""`
+
+	Sample         float64       `optional:"" help:"Keep this fraction of the selected calls, e.g. 0.1, or of the others with --keep-errors or --keep-slower-than (none by default)"`
+	SampleBy       string        `optional:"" default:"random" help:"Sample at random, by a hash of the call id (call-id) or by a hash of a request metadata value (metadata:<key>), which keeps the same calls across logs"`
+	MaxRate        []string      `optional:"" sep:"none" help:"Keep at most N selected calls per second for each method, or for each method matching a glob with glob=N; the first matching glob applies"`
+	KeepErrors     bool          `optional:"" help:"Keep all the selected calls that failed or were cancelled, regardless of sampling and rate caps, and only --sample of the others"`
+	KeepSlowerThan time.Duration `optional:"" help:"Keep all the selected calls that took longer than this, regardless of sampling and rate caps, and only --sample of the others"`
+
+	methodRegex *regexp.Regexp
+	codes       []codes.Code
+	eventTypes  map[v1.GrpcLogEntry_EventType]bool
+	logConfig   *binaryLogConfig
+	rateCaps    []rateCap
+	rateCounts  map[rateKey]int
+	// latest second a call started in, and the one at which rateCounts was last pruned
+	latestSecond, prunedSecond int64
+}
+
+// A pendingCall buffers the entries of a call until it is known whether the call is kept,
+// which for the status and elapsed criteria is only when the call ends.
+type pendingCall struct {
+	conversation
+	entries []*v1.GrpcLogEntry
+	cancel  *v1.GrpcLogEntry
+	// rejected by the criteria known from the client header, subsequent entries are discarded
+	dropped bool
+}
+
+func (cmd *FilterCmd) AfterApply() error {
+	if cmd.MethodRegex != "" {
+		re, err := regexp.Compile(cmd.MethodRegex)
+		if err != nil {
+			return fmt.Errorf("--method-regex: %w", err)
+		}
+		cmd.methodRegex = re
+	}
+	for _, s := range cmd.Status {
+		code, ok := parseCode(s)
+		if !ok {
+			return fmt.Errorf("--status: unknown status code %q", s)
+		}
+		cmd.codes = append(cmd.codes, code)
+	}
+	for _, m := range cmd.Metadata {
+		if !strings.Contains(m, "=") {
+			return fmt.Errorf("--metadata: expected key=glob, got %q", m)
+		}
+	}
+	if len(cmd.EventTypes) > 0 {
+		cmd.eventTypes = map[v1.GrpcLogEntry_EventType]bool{}
+		for _, t := range cmd.EventTypes {
+			name := "EVENT_TYPE_" + strings.ToUpper(strings.ReplaceAll(t, "-", "_"))
+			cmd.eventTypes[v1.GrpcLogEntry_EventType(v1.GrpcLogEntry_EventType_value[name])] = true
+		}
+	}
+	if cmd.LogConfig != "" {
+		c, err := parseBinaryLogConfig(cmd.LogConfig)
+		if err != nil {
+			return fmt.Errorf("--binary-log-filter: %w", err)
+		}
+		cmd.logConfig = c
+	}
+	if cmd.Sample < 0 || cmd.Sample > 1 {
+		return fmt.Errorf("--sample: expected a fraction between 0 and 1, got %v", cmd.Sample)
+	}
+	if cmd.SampleBy != "random" && cmd.SampleBy != "call-id" && !strings.HasPrefix(cmd.SampleBy, "metadata:") {
+		return fmt.Errorf("--sample-by: expected random, call-id or metadata:<key>, got %q", cmd.SampleBy)
+	}
+	// caps for all methods apply after the ones for specific methods
+	var defaults []rateCap
+	for _, s := range cmd.MaxRate {
+		r, err := parseRateCap(s)
+		if err != nil {
+			return fmt.Errorf("--max-rate: %w", err)
+		}
+		if r.pattern == "" {
+			defaults = append(defaults, r)
+		} else {
+			cmd.rateCaps = append(cmd.rateCaps, r)
+		}
+	}
+	cmd.rateCaps = append(cmd.rateCaps, defaults...)
+	cmd.rateCounts = map[rateKey]int{}
+	return nil
 }
 
 func (cmd *FilterCmd) Run(cli *Context) error {
@@ -26,28 +127,166 @@ func (cmd *FilterCmd) Run(cli *Context) error {
 	entries, errCh := reader.Read(ctx, f)
 
 	w := os.Stdout
+	var order []uint64
+	pending := map[uint64]*pendingCall{}
 	for e := range entries {
-		b, err := proto.Marshal(e)
-		if err != nil {
-			return err
+		c, found := pending[e.CallId]
+		if !found {
+			c = &pendingCall{}
+			pending[e.CallId] = c
+			order = append(order, e.CallId)
 		}
-		if cmd.CallID != 0 {
-			if e.CallId != cmd.CallID {
-				continue
+		if !c.dropped {
+			c.Record(e)
+			c.entries = append(c.entries, e)
+			if e.Type == v1.GrpcLogEntry_EVENT_TYPE_CANCEL {
+				c.cancel = e
+			}
+			if e.Type == v1.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER && !cmd.matchesHeader(c) {
+				c.dropped, c.entries = true, nil
 			}
 		}
 
-		if err := binary.Write(w, binary.BigEndian, uint32(len(b))); err != nil {
-			return err
+		if e.Type != v1.GrpcLogEntry_EVENT_TYPE_SERVER_TRAILER && e.Type != v1.GrpcLogEntry_EVENT_TYPE_CANCEL {
+			continue
 		}
-
-		if _, err := w.Write(b); err != nil {
-			return err
+		delete(pending, e.CallId)
+		if !c.dropped && cmd.matchesEnd(c) && cmd.sampled(c) {
+			if err := cmd.write(w, c); err != nil {
+				return err
+			}
 		}
 	}
 	if err := <-errCh; err != nil {
 		return err
 	}
 
+	// calls that never ended are kept unless a criterion needs their end
+	for _, id := range order {
+		c, found := pending[id]
+		if !found || c.dropped || len(cmd.codes) > 0 || cmd.MinElapsed > 0 || cmd.MaxElapsed > 0 {
+			continue
+		}
+		if c.requestHeader == nil && !cmd.matchesHeader(c) || !cmd.sampled(c) {
+			continue
+		}
+		if err := cmd.write(w, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchesHeader returns true if the call satisfies the criteria known from its client header.
+func (cmd *FilterCmd) matchesHeader(c *pendingCall) bool {
+	if cmd.CallID != 0 && c.entries[0].CallId != cmd.CallID {
+		return false
+	}
+	if cmd.logConfig != nil {
+		if _, ok := cmd.logConfig.limits(c.MethodName()); !ok {
+			return false
+		}
+	}
+	if len(cmd.Methods) > 0 || cmd.methodRegex != nil {
+		var ok bool
+		for _, m := range cmd.Methods {
+			ok = ok || matchMethod(m, c.MethodName())
+		}
+		if cmd.methodRegex != nil {
+			ok = ok || cmd.methodRegex.MatchString(c.MethodName())
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	start := c.entries[0].GetTimestamp().AsTime()
+	if !cmd.Since.IsZero() && start.Before(cmd.Since) {
+		return false
+	}
+	if !cmd.Until.IsZero() && !start.Before(cmd.Until) {
+		return false
+	}
+
+	if len(cmd.Peers) > 0 {
+		var ok bool
+		for _, p := range cmd.Peers {
+			ok = ok || matchValue(p, c.PeerAddress())
+		}
+		if !ok {
+			return false
+		}
+	}
+	for _, m := range cmd.Metadata {
+		key, pattern, _ := strings.Cut(m, "=")
+		if !matchValue(pattern, c.RequestHeaderValue(key)) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesEnd returns true if the call, which ended with a trailer or was cancelled, satisfies all the criteria.
+func (cmd *FilterCmd) matchesEnd(c *pendingCall) bool {
+	if c.requestHeader == nil && !cmd.matchesHeader(c) {
+		return false
+	}
+
+	code, end := codes.Canceled, c.cancel.GetTimestamp().AsTime()
+	if c.Completed() {
+		code, end = c.StatusCode(), c.responseTrailer.GetTimestamp().AsTime()
+	}
+	if len(cmd.codes) > 0 {
+		var ok bool
+		for _, want := range cmd.codes {
+			ok = ok || code == want
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	elapsed := end.Sub(c.entries[0].GetTimestamp().AsTime())
+	if cmd.MinElapsed > 0 && elapsed < cmd.MinElapsed {
+		return false
+	}
+	if cmd.MaxElapsed > 0 && elapsed > cmd.MaxElapsed {
+		return false
+	}
+	return true
+}
+
+// matchValue matches a glob against a value in which, unlike in method names, * and ? also match /.
+func matchValue(pattern, value string) bool {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.ReplaceAll(re, `\*`, ".*")
+	re = strings.ReplaceAll(re, `\?`, ".")
+	ok, _ := regexp.MatchString("^"+re+"$", value)
+	return ok
+}
+
+func (cmd *FilterCmd) write(w io.Writer, c *pendingCall) error {
+	var limits *binaryLogLimits
+	if cmd.logConfig != nil {
+		limits, _ = cmd.logConfig.limits(c.MethodName())
+	}
+	for _, e := range c.entries {
+		if cmd.eventTypes != nil && !cmd.eventTypes[e.Type] {
+			continue
+		}
+		if limits != nil {
+			e = limits.truncate(e)
+		}
+		b, err := proto.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, uint32(len(b))); err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
 	return nil
 }
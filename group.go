@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+)
+
+// A group is a set of conversations sharing the same values for the grouping keys.
+type group struct {
+	values        []string
+	conversations []conversation
+}
+
+// groupConversations partitions conversations by the values returned by keyFunc,
+// preserving the order in which each group first appears.
+func groupConversations(conversations []conversation, keyFunc func(conversation) []string) []*group {
+	var res []*group
+	byKey := map[string]*group{}
+	for _, c := range conversations {
+		values := keyFunc(c)
+		key := strings.Join(values, "\x00")
+		g, found := byKey[key]
+		if !found {
+			g = &group{values: values}
+			byKey[key] = g
+			res = append(res, g)
+		}
+		g.conversations = append(g.conversations, c)
+	}
+	return res
+}
+
+// groupColumn returns the column title for a grouping key.
+func groupColumn(key string) string {
+	switch key {
+	case "method":
+		return "Method"
+	case "service":
+		return "Service"
+	case "status":
+		return "Status"
+	case "peer":
+		return "Peer"
+	case "authority":
+		return "Authority"
+	default:
+		return strings.ToLower(key)
+	}
+}
+
+// groupValue returns the value of the grouping key for a conversation.
+// Keys other than the well known ones are interpreted as request header names.
+func groupValue(c conversation, key string) string {
+	switch key {
+	case "method":
+		return c.MethodName()
+	case "service":
+		return c.ServiceName()
+	case "status":
+		if !c.Completed() {
+			return "(never)"
+		}
+		return c.StatusCode().String()
+	case "peer":
+		return c.PeerAddress()
+	case "authority":
+		return c.requestHeader.GetClientHeader().GetAuthority()
+	default:
+		return c.RequestHeaderValue(key)
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	v1 "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type InferSchemaCmd struct {
+	CmdCommon
+
+	OutDir string `optional:"" type:"path" help:"Write one .proto file per package into this directory instead of printing it"`
+}
+
+// An inferredMessage accumulates what was seen on the wire for a message type.
+type inferredMessage struct {
+	name   string
+	fields map[protowire.Number]*inferredField
+}
+
+// An inferredField accumulates the values seen for a field number. The type is decided once all
+// the values have been seen, e.g. a bytes field is a string only if all its values are printable.
+type inferredField struct {
+	wireType protowire.Type
+	// seen with different wire types, no type can be inferred
+	conflict bool
+	// seen more than once in a message
+	repeated bool
+
+	maxVarint  uint64
+	allFloat   bool
+	allString  bool
+	allMessage bool
+	nonEmpty   bool
+	nested     *inferredMessage
+
+	// bytes values that all parse as packed repeated scalars, with the same statistics as above
+	allPackedVarint, allPacked32, allPacked64 bool
+	maxPackedVarint                           uint64
+	allPackedFloat, allPackedDouble           bool
+	// all zeros are plausible floats too, but more likely integers
+	packedNonZero bool
+}
+
+func newInferredMessage(name string) *inferredMessage {
+	return &inferredMessage{name: name, fields: map[protowire.Number]*inferredField{}}
+}
+
+// observe records the fields of a serialized message. Fields are recorded up to the first
+// malformed one, so that truncated payloads contribute their complete fields.
+func (m *inferredMessage) observe(b []byte) error {
+	seen := map[protowire.Number]bool{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		value := b[:n]
+		b = b[n:]
+		// groups are deprecated and not inferred
+		if typ == protowire.StartGroupType {
+			continue
+		}
+
+		f, found := m.fields[num]
+		if !found {
+			f = &inferredField{wireType: typ, allFloat: true, allString: true, allMessage: true,
+				allPackedVarint: true, allPacked32: true, allPacked64: true, allPackedFloat: true, allPackedDouble: true}
+			m.fields[num] = f
+		}
+		if f.wireType != typ {
+			f.conflict = true
+		}
+		if seen[num] {
+			f.repeated = true
+		}
+		seen[num] = true
+		if f.conflict {
+			continue
+		}
+		f.observe(num, typ, value)
+	}
+	return nil
+}
+
+func (f *inferredField) observe(num protowire.Number, typ protowire.Type, value []byte) {
+	switch typ {
+	case protowire.VarintType:
+		v, _ := protowire.ConsumeVarint(value)
+		if v > f.maxVarint {
+			f.maxVarint = v
+		}
+	case protowire.Fixed32Type:
+		v, _ := protowire.ConsumeFixed32(value)
+		f.allFloat = f.allFloat && plausibleFloat(float64(math.Float32frombits(v)))
+	case protowire.Fixed64Type:
+		v, _ := protowire.ConsumeFixed64(value)
+		f.allFloat = f.allFloat && plausibleFloat(math.Float64frombits(v))
+	case protowire.BytesType:
+		v, _ := protowire.ConsumeBytes(value)
+		if len(v) == 0 {
+			// an empty value fits any type
+			return
+		}
+		f.nonEmpty = true
+		f.allString = f.allString && printable(v)
+		f.allMessage = f.allMessage && parsesAsMessage(v)
+		if f.allMessage {
+			if f.nested == nil {
+				f.nested = newInferredMessage(fmt.Sprintf("Field%d", num))
+			}
+			_ = f.nested.observe(v)
+		}
+		f.observePacked(v)
+	}
+}
+
+// observePacked records whether a bytes value parses as packed varints, fixed32 or fixed64 values.
+func (f *inferredField) observePacked(v []byte) {
+	f.packedNonZero = f.packedNonZero || strings.Trim(string(v), "\x00") != ""
+	for b := v; f.allPackedVarint && len(b) > 0; {
+		x, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			f.allPackedVarint = false
+			break
+		}
+		if x > f.maxPackedVarint {
+			f.maxPackedVarint = x
+		}
+		b = b[n:]
+	}
+	f.allPacked32 = f.allPacked32 && len(v)%4 == 0
+	for b := v; f.allPacked32 && f.allPackedFloat && len(b) > 0; b = b[4:] {
+		x, _ := protowire.ConsumeFixed32(b)
+		f.allPackedFloat = plausibleFloat(float64(math.Float32frombits(x)))
+	}
+	f.allPacked64 = f.allPacked64 && len(v)%8 == 0
+	for b := v; f.allPacked64 && f.allPackedDouble && len(b) > 0; b = b[8:] {
+		x, _ := protowire.ConsumeFixed64(b)
+		f.allPackedDouble = plausibleFloat(math.Float64frombits(x))
+	}
+}
+
+// plausibleFloat returns true if v looks like a floating point value rather than an integer
+// reinterpreted as one, which usually has an extreme exponent.
+func plausibleFloat(v float64) bool {
+	if v == 0 {
+		return true
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return false
+	}
+	v = math.Abs(v)
+	return v >= 1e-9 && v <= 1e15
+}
+
+func printable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func parsesAsMessage(b []byte) bool {
+	for len(b) > 0 {
+		_, _, n := protowire.ConsumeField(b)
+		if n < 0 {
+			return false
+		}
+		b = b[n:]
+	}
+	return true
+}
+
+// typeName returns the proto type of the field, the nested message to declare, if any, and
+// whether the values are packed repeated scalars.
+func (f *inferredField) typeName() (string, *inferredMessage, bool) {
+	switch f.wireType {
+	case protowire.VarintType:
+		return varintType(f.maxVarint), nil, false
+	case protowire.Fixed32Type:
+		if f.allFloat {
+			return "float", nil, false
+		}
+		return "fixed32", nil, false
+	case protowire.Fixed64Type:
+		if f.allFloat {
+			return "double", nil, false
+		}
+		return "fixed64", nil, false
+	default:
+		switch {
+		case !f.nonEmpty:
+			return "bytes", nil, false
+		case f.allString:
+			// short printable messages are indistinguishable from strings, strings are far more common
+			return "string", nil, false
+		case f.allMessage:
+			return f.nested.name, f.nested, false
+		// most byte strings ending with a byte below 0x80 parse as varints, so fixed values are
+		// only preferred when they look like floating point values. Pairs of floats usually look
+		// like a plausible double too, while the low half of a double rarely looks like a float.
+		case f.packedNonZero && f.allPacked32 && f.allPackedFloat:
+			return "float", nil, true
+		case f.packedNonZero && f.allPacked64 && f.allPackedDouble:
+			return "double", nil, true
+		case f.allPackedVarint:
+			return varintType(f.maxPackedVarint), nil, true
+		case f.allPacked64:
+			return "fixed64", nil, true
+		case f.allPacked32:
+			return "fixed32", nil, true
+		default:
+			return "bytes", nil, false
+		}
+	}
+}
+
+func varintType(max uint64) string {
+	switch {
+	case max <= 1:
+		return "bool"
+	case max <= math.MaxInt32:
+		return "int32"
+	default:
+		return "int64"
+	}
+}
+
+func (m *inferredMessage) write(w io.Writer, indent string) {
+	fmt.Fprintf(w, "%smessage %s {\n", indent, m.name)
+	numbers := make([]protowire.Number, 0, len(m.fields))
+	for num := range m.fields {
+		numbers = append(numbers, num)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	var nested []*inferredMessage
+	for _, num := range numbers {
+		f := m.fields[num]
+		if f.conflict {
+			fmt.Fprintf(w, "%s  // field %d was seen with different wire types\n", indent, num)
+			continue
+		}
+		typ, msg, packed := f.typeName()
+		if msg != nil {
+			nested = append(nested, msg)
+		}
+		if f.repeated || packed {
+			typ = "repeated " + typ
+		}
+		fmt.Fprintf(w, "%s  %s field_%d = %d;\n", indent, typ, num, num)
+	}
+	for _, msg := range nested {
+		fmt.Fprintln(w)
+		msg.write(w, indent+"  ")
+	}
+	fmt.Fprintf(w, "%s}\n", indent)
+}
+
+type inferredMethod struct {
+	name                             string
+	request, response                *inferredMessage
+	clientStreaming, serverStreaming bool
+}
+
+// An inferredFile holds the services of a package and their messages.
+type inferredFile struct {
+	pkg      string
+	services map[string]map[string]*inferredMethod
+	names    map[string]bool
+}
+
+func (f *inferredFile) method(service, method string) *inferredMethod {
+	methods, found := f.services[service]
+	if !found {
+		methods = map[string]*inferredMethod{}
+		f.services[service] = methods
+	}
+	m, found := methods[method]
+	if !found {
+		m = &inferredMethod{name: method, request: newInferredMessage(f.messageName(service, method, "Request")), response: newInferredMessage(f.messageName(service, method, "Response"))}
+		methods[method] = m
+	}
+	return m
+}
+
+// messageName names messages after their method, prefixed by the service on collisions.
+func (f *inferredFile) messageName(service, method, suffix string) string {
+	name := method + suffix
+	if f.names[name] {
+		name = service + name
+	}
+	f.names[name] = true
+	return name
+}
+
+func (f *inferredFile) fileName() string {
+	if f.pkg == "" {
+		return "inferred.proto"
+	}
+	return f.pkg + ".proto"
+}
+
+func (f *inferredFile) write(w io.Writer, source string) {
+	fmt.Fprintf(w, "// Inferred from the traffic in %s, field names and types are guesses.\n", filepath.Base(source))
+	fmt.Fprintf(w, "syntax = \"proto3\";\n")
+	if f.pkg != "" {
+		fmt.Fprintf(w, "\npackage %s;\n", f.pkg)
+	}
+
+	var messages []*inferredMessage
+	for _, service := range sortedKeys(f.services) {
+		fmt.Fprintf(w, "\nservice %s {\n", service)
+		methods := f.services[service]
+		for _, name := range sortedKeys(methods) {
+			m := methods[name]
+			var reqStream, respStream string
+			if m.clientStreaming {
+				reqStream = "stream "
+			}
+			if m.serverStreaming {
+				respStream = "stream "
+			}
+			fmt.Fprintf(w, "  rpc %s(%s%s) returns (%s%s);\n", m.name, reqStream, m.request.name, respStream, m.response.name)
+			messages = append(messages, m.request, m.response)
+		}
+		fmt.Fprintf(w, "}\n")
+	}
+	for _, m := range messages {
+		fmt.Fprintln(w)
+		m.write(w, "")
+	}
+}
+
+func (cmd *InferSchemaCmd) Run(cli *Context) error {
+	f, err := openFile(cmd.LogInputFile, cli.Follow)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	conversations, err := readConversations(cli, f)
+	if err != nil {
+		return err
+	}
+
+	files := map[string]*inferredFile{}
+	for _, c := range conversations {
+		if c.CallId() == 0 {
+			continue
+		}
+		// methods are named /package.Service/Method
+		name := strings.TrimPrefix(c.MethodName(), "/")
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			log.Printf("call %d: skipping invalid method name %q", c.CallId(), c.MethodName())
+			continue
+		}
+		service, method := name[:i], name[i+1:]
+		var pkg string
+		if j := strings.LastIndex(service, "."); j >= 0 {
+			pkg, service = service[:j], service[j+1:]
+		}
+		file, found := files[pkg]
+		if !found {
+			file = &inferredFile{pkg: pkg, services: map[string]map[string]*inferredMethod{}, names: map[string]bool{}}
+			files[pkg] = file
+		}
+
+		m := file.method(service, method)
+		m.clientStreaming = m.clientStreaming || len(c.requestMessages) > 1
+		m.serverStreaming = m.serverStreaming || len(c.responseMessages) > 1
+		observe := func(msg *inferredMessage, entries []*v1.GrpcLogEntry) {
+			for _, e := range entries {
+				if err := msg.observe(e.GetMessage().GetData()); err != nil && !e.PayloadTruncated {
+					log.Printf("call %d: %s: %v", c.CallId(), c.MethodName(), err)
+				}
+			}
+		}
+		observe(m.request, c.requestMessages)
+		observe(m.response, c.responseMessages)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no calls found")
+	}
+
+	if cmd.OutDir == "" {
+		if len(files) > 1 {
+			return fmt.Errorf("calls of %d packages found, use --out-dir to write one file per package", len(files))
+		}
+		for _, file := range files {
+			file.write(os.Stdout, cmd.LogInputFile)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(cmd.OutDir, 0o755); err != nil {
+		return err
+	}
+	for _, pkg := range sortedKeys(files) {
+		var b strings.Builder
+		files[pkg].write(&b, cmd.LogInputFile)
+		filename := filepath.Join(cmd.OutDir, files[pkg].fileName())
+		if err := os.WriteFile(filename, []byte(b.String()), 0o644); err != nil {
+			return err
+		}
+		log.Printf("wrote %s", filename)
+	}
+	return nil
+}
@@ -8,12 +8,15 @@ import (
 	"os"
 	"runtime/pprof"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/alecthomas/kong"
+	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/desc/protoparse"
 	"github.com/mkmik/tail"
 	v1 "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
@@ -29,7 +32,13 @@ type Context struct {
 type CLI struct {
 	ProtoFileNames []string `optional:"" name:"proto" short:"p" help:"Proto files" type:"string" env:"BINLOG_PROTO_FILES"`
 	ImportPaths    []string `optional:"" name:"proto_path" short:"I" help:"Import paths" type:"string" env:"BINLOG_IMPORT_PATH"`
-	DescSet        []string `optional:"" name:"descriptor_set" help:"path to FileDescriptorSet, see protoc -o"`
+	ProtoDirs      []string `optional:"" name:"proto-dir" help:"Directories to recursively search for proto files" type:"path"`
+	DescSet        []string `optional:"" name:"descriptor_set" help:"path to FileDescriptorSet (see protoc -o) or Buf image (see buf build -o), optionally gzip compressed"`
+	Reflect        string   `optional:"" name:"reflect" help:"address of a gRPC server to fetch descriptors from, using the server reflection service"`
+	DescBinaries   []string `optional:"" name:"descriptors-from-binary" help:"Go binaries (ELF) to extract the embedded descriptors of generated protobuf code from"`
+	MethodTypes    []string `optional:"" name:"method-type" sep:"none" help:"Map a method, or a glob pattern matching methods, to its message types, e.g. '/pkg.Svc/Method=pkg.Req:pkg.Resp'"`
+	MethodMapFile  string   `optional:"" name:"method-type-file" type:"existingfile" help:"File containing one --method-type mapping per line"`
+	DescPrecedence []string `optional:"" name:"descriptor-precedence" help:"Order in which descriptor sources (proto, proto-dir, descriptor-set, binary, reflect) take precedence when they define the same file differently"`
 	CPUProfile     string   `optional:"" name:"cpuprofile" help:"write cpu profile to file"`
 	Follow         bool     `optional:"" name:"follow" short:"f" help:"Tail the file"`
 
@@ -44,7 +53,15 @@ type CLI struct {
 	Recv   RecvCmd   `cmd:"" help:"Exposes a gRPC server that receives binary logs" name:"receive"`
 	Fetch  FetchCmd  `cmd:"" help:"Read gRPC binlog entries from remote collector"`
 
-	methods map[string]methodTypes
+	Exporter    ExporterCmd    `cmd:"" help:"Follow binary logs and serve Prometheus metrics over HTTP"`
+	Concurrency ConcurrencyCmd `cmd:"" help:"Report how many calls were in flight over time"`
+	Assert      AssertCmd      `cmd:"" help:"Check that the calls in a binary log satisfy latency and error rate rules"`
+	SchemaCheck SchemaCheckCmd `cmd:"" help:"Report messages with fields unknown to the descriptors or that cannot be decoded"`
+	Compat      CompatCmd      `cmd:"" help:"Check whether recorded messages keep their meaning when decoded with a new descriptor set"`
+	InferSchema InferSchemaCmd `cmd:"" help:"Infer a .proto file from the messages of a binary log"`
+
+	methods        map[string]methodTypes
+	methodMappings []methodMapping
 }
 
 type methodTypes struct {
@@ -57,6 +74,28 @@ type CmdCommon struct {
 }
 
 func (c *CLI) AfterApply() error {
+	if err := c.registerDescriptors(); err != nil {
+		return err
+	}
+	if err := c.registerServices(); err != nil {
+		return fmt.Errorf("registerServices: %w", err)
+	}
+	if err := c.registerMethodMappings(); err != nil {
+		return fmt.Errorf("method types: %w", err)
+	}
+	if c.CPUProfile != "" {
+		c.startCPUProfile()
+	}
+	return nil
+}
+
+// registerDescriptors collects file descriptors from all the sources and registers them.
+func (c *CLI) registerDescriptors() error {
+	r, err := newDescriptorResolver(c.DescPrecedence)
+	if err != nil {
+		return err
+	}
+
 	p := &protoparse.Parser{
 		ImportPaths: c.ImportPaths,
 	}
@@ -64,19 +103,48 @@ func (c *CLI) AfterApply() error {
 	if err != nil {
 		return err
 	}
-	if err := registerFileDescriptors(fds); err != nil {
-		return fmt.Errorf("registerFileDescriptors: %w", err)
+	for _, fd := range fds {
+		if err := r.add("proto", fmt.Sprintf("proto file %s", fd.GetName()), withDependencies([]*desc.FileDescriptor{fd})...); err != nil {
+			return err
+		}
 	}
-	if err := registerFileDescriptorSets(c.DescSet); err != nil {
-		return fmt.Errorf("registerFileDescriptorSets: %w", err)
+	for _, dir := range c.ProtoDirs {
+		fdps, err := protoDirFileDescriptors(dir, c.ImportPaths)
+		if err != nil {
+			return fmt.Errorf("proto-dir %s: %w", dir, err)
+		}
+		if err := r.add("proto-dir", fmt.Sprintf("proto dir %s", dir), fdps...); err != nil {
+			return err
+		}
 	}
-	if err := c.registerServices(); err != nil {
-		return fmt.Errorf("registerServices: %w", err)
+	for _, filename := range c.DescSet {
+		fdset, err := readFileDescriptorSet(filename)
+		if err != nil {
+			return fmt.Errorf("descriptor set %s: %w", filename, err)
+		}
+		if err := r.add("descriptor-set", fmt.Sprintf("descriptor set %s", filename), fdset.File...); err != nil {
+			return err
+		}
 	}
-	if c.CPUProfile != "" {
-		c.startCPUProfile()
+	for _, filename := range c.DescBinaries {
+		fdps, err := binaryFileDescriptors(filename)
+		if err != nil {
+			return fmt.Errorf("binary %s: %w", filename, err)
+		}
+		if err := r.add("binary", fmt.Sprintf("binary %s", filename), fdps...); err != nil {
+			return err
+		}
 	}
-	return nil
+	if c.Reflect != "" {
+		fds, err := reflectedFileDescriptors(c.Reflect)
+		if err != nil {
+			return fmt.Errorf("reflection %s: %w", c.Reflect, err)
+		}
+		if err := r.add("reflect", fmt.Sprintf("reflection %s", c.Reflect), withDependencies(fds)...); err != nil {
+			return err
+		}
+	}
+	return r.register()
 }
 
 func (c *CLI) startCPUProfile() {
@@ -199,6 +267,23 @@ type conversation struct {
 	responseTrailer  *v1.GrpcLogEntry
 }
 
+// entries returns all the recorded log entries of the conversation.
+func (c conversation) entries() []*v1.GrpcLogEntry {
+	var res []*v1.GrpcLogEntry
+	if c.requestHeader != nil {
+		res = append(res, c.requestHeader)
+	}
+	res = append(res, c.requestMessages...)
+	if c.responseHeader != nil {
+		res = append(res, c.responseHeader)
+	}
+	res = append(res, c.responseMessages...)
+	if c.responseTrailer != nil {
+		res = append(res, c.responseTrailer)
+	}
+	return res
+}
+
 func (c conversation) CallId() uint64 {
 	return c.requestHeader.GetCallId()
 }
@@ -207,6 +292,32 @@ func (c conversation) MethodName() string {
 	return c.requestHeader.GetClientHeader().GetMethodName()
 }
 
+// ServiceName returns the fully qualified name of the service, e.g. "helloworld.Greeter".
+func (c conversation) ServiceName() string {
+	service, _, _ := strings.Cut(strings.TrimPrefix(c.MethodName(), "/"), "/")
+	return service
+}
+
+// PeerAddress returns the address of the remote peer, without port.
+func (c conversation) PeerAddress() string {
+	if p := c.requestHeader.GetPeer(); p != nil {
+		return p.GetAddress()
+	}
+	return c.responseHeader.GetPeer().GetAddress()
+}
+
+// RequestHeaderValue returns the comma separated values of the request metadata entries with the given key.
+func (c conversation) RequestHeaderValue(key string) string {
+	key = strings.ToLower(key)
+	var values []string
+	for _, e := range c.requestHeader.GetClientHeader().GetMetadata().GetEntry() {
+		if e.GetKey() == key {
+			values = append(values, string(e.GetValue()))
+		}
+	}
+	return strings.Join(values, ",")
+}
+
 func (c conversation) Timestamp() string {
 	// use same format as /debug/requests (https://cs.opensource.google/go/x/net/+/e204ce36:trace/trace.go;l=888)
 	return c.requestHeader.Timestamp.AsTime().Format("2006/01/02 15:04:05.000000")
@@ -224,6 +335,15 @@ func (c conversation) ElapsedDuration() time.Duration {
 	return c.responseTrailer.GetTimestamp().AsTime().Sub(c.requestHeader.GetTimestamp().AsTime())
 }
 
+// Completed returns true if the conversation has a trailer and thus a status code.
+func (c conversation) Completed() bool {
+	return c.responseTrailer.GetTrailer() != nil
+}
+
+func (c conversation) StatusCode() codes.Code {
+	return codes.Code(c.responseTrailer.GetTrailer().GetStatusCode())
+}
+
 func formatMessages(w io.Writer, prefix string, entries []*v1.GrpcLogEntry, messageType string) error {
 	for _, m := range entries {
 		b, err := formatEntry(m, messageType)
@@ -254,7 +374,7 @@ func (c conversation) FormatResponse(w io.Writer, ctx *Context) error {
 }
 
 func (c conversation) RequestMessageType(ctx *Context) (string, error) {
-	md, ok := ctx.methods[c.MethodName()]
+	md, ok := ctx.lookupMethod(c.MethodName())
 	if !ok {
 		return "", fmt.Errorf("cannot find method descriptor for %q", c.MethodName())
 	}
@@ -262,7 +382,7 @@ func (c conversation) RequestMessageType(ctx *Context) (string, error) {
 }
 
 func (c conversation) ResponseMessageType(ctx *Context) (string, error) {
-	md, ok := ctx.methods[c.MethodName()]
+	md, ok := ctx.lookupMethod(c.MethodName())
 	if !ok {
 		return "", fmt.Errorf("cannot find method descriptor for %q", c.MethodName())
 	}
@@ -271,17 +391,42 @@ func (c conversation) ResponseMessageType(ctx *Context) (string, error) {
 
 func formatEntry(entry *v1.GrpcLogEntry, messageType string) ([]byte, error) {
 	raw := entry.GetMessage().GetData()
-	msg, err := parseBody(raw, messageType)
+	var (
+		msg     proto.Message
+		cutPath string
+		err     error
+	)
+	if entry.PayloadTruncated {
+		msg, cutPath, err = parseTruncatedBody(raw, messageType)
+	} else {
+		msg, err = parseBody(raw, messageType)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := protojson.MarshalOptions{Multiline: true}.Marshal(msg)
+	res, err := marshalMessage(msg)
 	if err != nil {
-		return nil, fmt.Errorf("cannot marshal dynamic proto: %w", err)
+		return nil, err
+	}
+	// protojson drops unknown fields, render them separately.
+	if unknown := unknownFields(msg.ProtoReflect()); len(unknown) > 0 {
+		res = append(res, fmt.Sprintf("\n(unknown fields: %s)", renderUnknownFields(unknown))...)
 	}
 	if entry.PayloadTruncated {
-		res = append(res, []byte("...")...)
+		marker := "\n... truncated"
+		if cutPath != "" {
+			marker += " in " + cutPath
+		}
+		res = append(res, fmt.Sprintf("%s (captured %d of %d bytes)", marker, len(raw), entry.GetMessage().GetLength())...)
+	}
+	return res, nil
+}
+
+func marshalMessage(msg proto.Message) ([]byte, error) {
+	res, err := protojson.MarshalOptions{Multiline: true, Resolver: registeredTypes()}.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal dynamic proto: %w", err)
 	}
 	return res, nil
 }
@@ -291,12 +436,27 @@ func parseBody(raw []byte, messageType string) (proto.Message, error) {
 	if err != nil {
 		return nil, err
 	}
-	if err := proto.Unmarshal(raw, msg); err != nil {
+	if err := (proto.UnmarshalOptions{Resolver: registeredTypes()}).Unmarshal(raw, msg); err != nil {
 		return nil, fmt.Errorf("cannot dynamicaly unmarshal raw message %w", err)
 	}
 	return msg, nil
 }
 
+var (
+	typesOnce sync.Once
+	types     *dynamicpb.Types
+)
+
+// registeredTypes returns a resolver for the message and extension types of all the registered
+// files, used to expand google.protobuf.Any payloads and to decode extensions.
+// Must not be called before all the descriptors have been registered.
+func registeredTypes() *dynamicpb.Types {
+	typesOnce.Do(func() {
+		types = dynamicpb.NewTypes(protoregistry.GlobalFiles)
+	})
+	return types
+}
+
 func newDynamicMessage(messageType string) (proto.Message, error) {
 	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(messageType))
 	if err != nil {
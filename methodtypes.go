@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// A methodMapping explicitly maps a method, or a glob pattern matching methods,
+// to its request and response message types.
+type methodMapping struct {
+	pattern string
+	types   methodTypes
+}
+
+// parseMethodMapping parses mappings like "/pkg.Svc/Method=pkg.Req:pkg.Resp".
+func parseMethodMapping(s string) (methodMapping, error) {
+	pattern, types, found := strings.Cut(s, "=")
+	if !found {
+		return methodMapping{}, fmt.Errorf("%q: expected /pkg.Service/Method=pkg.Request:pkg.Response", s)
+	}
+	req, res, found := strings.Cut(types, ":")
+	if !found {
+		return methodMapping{}, fmt.Errorf("%q: expected request and response types separated by ':'", s)
+	}
+	pattern = strings.TrimSpace(pattern)
+	if !strings.HasPrefix(pattern, "/") {
+		pattern = "/" + pattern
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return methodMapping{}, fmt.Errorf("%q: bad pattern: %w", s, err)
+	}
+	m := methodMapping{
+		pattern: pattern,
+		types: methodTypes{
+			requestMessageType:  protoreflect.FullName(strings.TrimSpace(req)),
+			responseMessageType: protoreflect.FullName(strings.TrimSpace(res)),
+		},
+	}
+	for _, t := range []protoreflect.FullName{m.types.requestMessageType, m.types.responseMessageType} {
+		desc, err := protoregistry.GlobalFiles.FindDescriptorByName(t)
+		if err != nil {
+			return methodMapping{}, fmt.Errorf("%q: cannot find descriptor for %q: %w", s, t, err)
+		}
+		if _, ok := desc.(protoreflect.MessageDescriptor); !ok {
+			return methodMapping{}, fmt.Errorf("%q: %s is not a message", s, t)
+		}
+	}
+	return m, nil
+}
+
+// registerMethodMappings parses the method mappings given in the mapping file and on the command line.
+// Must be called after all descriptors have been registered.
+func (c *CLI) registerMethodMappings() error {
+	var specs []string
+	if c.MethodMapFile != "" {
+		f, err := os.Open(c.MethodMapFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			specs = append(specs, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	specs = append(specs, c.MethodTypes...)
+
+	for _, s := range specs {
+		m, err := parseMethodMapping(s)
+		if err != nil {
+			return err
+		}
+		c.methodMappings = append(c.methodMappings, m)
+	}
+	return nil
+}
+
+// lookupMethod returns the message types of a method. Explicit mappings take precedence over
+// the services found in the descriptors, exact mappings over patterns, and later mappings over earlier ones.
+func (c *CLI) lookupMethod(name string) (methodTypes, bool) {
+	for i := len(c.methodMappings) - 1; i >= 0; i-- {
+		if m := c.methodMappings[i]; m.pattern == name {
+			return m.types, true
+		}
+	}
+	for i := len(c.methodMappings) - 1; i >= 0; i-- {
+		if m := c.methodMappings[i]; matchMethod(m.pattern, name) {
+			return m.types, true
+		}
+	}
+	md, ok := c.methods[name]
+	return md, ok
+}
+
+func matchMethod(pattern, name string) bool {
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
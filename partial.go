@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// parseTruncatedBody decodes as many complete fields as possible out of a payload that was truncated
+// by the binary logger. It returns the partially decoded message and the path of the field that was
+// being decoded when the payload ended, empty if it ended between two top level fields.
+func parseTruncatedBody(raw []byte, messageType string) (proto.Message, string, error) {
+	msg, err := newDynamicMessage(messageType)
+	if err != nil {
+		return nil, "", err
+	}
+	path, err := partialUnmarshal(raw, msg.ProtoReflect(), "", registeredTypes())
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot dynamicaly unmarshal truncated message %w", err)
+	}
+	return msg, path, nil
+}
+
+// typeResolver resolves the types of Any payloads and extensions.
+type typeResolver interface {
+	protoregistry.ExtensionTypeResolver
+	protoregistry.MessageTypeResolver
+}
+
+// partialUnmarshal merges the complete fields of b into m. If the last field is an incomplete
+// message, its complete fields are decoded recursively.
+func partialUnmarshal(b []byte, m protoreflect.Message, prefix string, resolver typeResolver) (string, error) {
+	var off int
+	for off < len(b) {
+		_, _, n := protowire.ConsumeField(b[off:])
+		if n < 0 {
+			break
+		}
+		off += n
+	}
+	// required fields may well be in the missing part of the payload
+	opts := proto.UnmarshalOptions{Merge: true, AllowPartial: true, Resolver: resolver}
+	if err := opts.Unmarshal(b[:off], m.Interface()); err != nil {
+		return "", err
+	}
+	if off == len(b) {
+		return strings.TrimSuffix(prefix, "."), nil
+	}
+
+	rest := b[off:]
+	num, typ, n := protowire.ConsumeTag(rest)
+	if n < 0 {
+		return strings.TrimSuffix(prefix, "."), nil
+	}
+	fd := m.Descriptor().Fields().ByNumber(num)
+	if fd == nil {
+		return fmt.Sprintf("%s%d", prefix, num), nil
+	}
+	name := prefix + string(fd.Name())
+	if typ != protowire.BytesType || fd.Message() == nil || fd.IsMap() {
+		return name, nil
+	}
+	rest = rest[n:]
+	_, n = protowire.ConsumeVarint(rest)
+	if n < 0 {
+		return name, nil
+	}
+	rest = rest[n:]
+
+	if fd.IsList() {
+		l := m.Mutable(fd).List()
+		e := l.NewElement()
+		path, err := partialUnmarshal(rest, e.Message(), fmt.Sprintf("%s[%d].", name, l.Len()), resolver)
+		if err != nil {
+			return "", err
+		}
+		l.Append(e)
+		return path, nil
+	}
+	return partialUnmarshal(rest, m.Mutable(fd).Message(), name+".", resolver)
+}
@@ -1,11 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io/ioutil"
-	"os"
 
-	"github.com/jhump/protoreflect/desc"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/reflect/protoregistry"
@@ -13,35 +14,40 @@ import (
 	"google.golang.org/protobuf/types/dynamicpb"
 )
 
-func registerFileDescriptorSets(filenames []string) error {
-	for _, descSetFileName := range filenames {
-		b, err := ioutil.ReadFile(descSetFileName)
-		if os.IsNotExist(err) {
-			continue
-		}
+// readFileDescriptorSet reads a FileDescriptorSet (see protoc -o) or a Buf image (see buf build -o),
+// either binary or JSON encoded, optionally gzip compressed.
+func readFileDescriptorSet(filename string) (*descriptorpb.FileDescriptorSet, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(b, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(b))
 		if err != nil {
-			return err
+			return nil, err
 		}
-		var fdset descriptorpb.FileDescriptorSet
-		if err := proto.Unmarshal(b, &fdset); err != nil {
-			return err
-		}
-		for _, fdp := range fdset.File {
-			if err := registerFileDescriptor(fdp); err != nil {
-				return fmt.Errorf("fdset %s, file %s: %w", descSetFileName, fdp.GetName(), err)
-			}
+		if b, err = ioutil.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("decompressing: %w", err)
 		}
 	}
-	return nil
-}
 
-func registerFileDescriptors(fds []*desc.FileDescriptor) error {
-	for _, fd := range fds {
-		if err := registerFileDescriptor(fd.AsFileDescriptorProto()); err != nil {
-			return err
+	var fdset descriptorpb.FileDescriptorSet
+	if trimmed := bytes.TrimLeft(b, " \t\r\n"); len(trimmed) > 0 && trimmed[0] == '{' {
+		// the JSON encoding of Buf images has additional bufExtension fields.
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(b, &fdset); err != nil {
+			return nil, err
 		}
+		return &fdset, nil
 	}
-	return nil
+	if err := proto.Unmarshal(b, &fdset); err != nil {
+		return nil, err
+	}
+	// Buf images are wire compatible with FileDescriptorSet, except for an additional
+	// buf_extension field in each file, which we drop.
+	for _, fdp := range fdset.File {
+		fdp.ProtoReflect().SetUnknown(nil)
+	}
+	return &fdset, nil
 }
 
 func registerFileDescriptor(fdp *descriptorpb.FileDescriptorProto) error {
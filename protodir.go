@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// protoDirFileDescriptors compiles all the proto files found under dir. Files are named relative
+// to dir, which is also used as the first import path. Files that fail to compile are reported and skipped.
+//
+// The compiled descriptors are cached in the user cache directory, keyed by the hash
+// of the contents of all the proto files and of the import paths. The files imported from
+// the import paths are hashed into the cache entry, which is ignored once any of them changes.
+func protoDirFileDescriptors(dir string, importPaths []string) ([]*descriptorpb.FileDescriptorProto, error) {
+	if fi, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("not a directory")
+	}
+	var names []string
+	h := sha256.New()
+	fmt.Fprintf(h, "%q\n", importPaths)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".proto" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fh := sha256.Sum256(b)
+		fmt.Fprintf(h, "%s %x\n", filepath.ToSlash(rel), fh)
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no proto files found in %s", dir)
+	}
+
+	searchPaths := append([]string{dir}, importPaths...)
+	cacheFile := protoDirCacheFile(hex.EncodeToString(h.Sum(nil)))
+	if cacheFile != "" {
+		if c, err := readProtoDirCache(cacheFile); err == nil && c.valid(searchPaths) {
+			for _, s := range c.Skipped {
+				log.Printf("proto-dir: skipping %s: %s", s.Name, s.Error)
+			}
+			var fdset descriptorpb.FileDescriptorSet
+			if err := proto.Unmarshal(c.Descriptors, &fdset); err == nil {
+				return fdset.File, nil
+			}
+		}
+	}
+
+	p := &protoparse.Parser{
+		ImportPaths: searchPaths,
+	}
+	var skipped []skippedProtoFile
+	fds, err := p.ParseFiles(names...)
+	if err != nil {
+		// find out which files fail to compile, by compiling them one at a time.
+		fds = nil
+		for _, name := range names {
+			fd, err := p.ParseFiles(name)
+			if err != nil {
+				log.Printf("proto-dir: skipping %s: %v", name, err)
+				skipped = append(skipped, skippedProtoFile{Name: name, Error: err.Error()})
+				continue
+			}
+			fds = append(fds, fd...)
+		}
+	}
+
+	res := &descriptorpb.FileDescriptorSet{File: withDependencies(fds)}
+	if cacheFile != "" {
+		c := &protoDirCache{Imports: importedFiles(p, names, res.File, skipped), Skipped: skipped}
+		if err := c.write(cacheFile, res); err != nil {
+			log.Printf("proto-dir: cannot write cache: %v", err)
+		}
+	}
+	return res.File, nil
+}
+
+// A protoDirCache is the cached result of compiling a proto dir.
+type protoDirCache struct {
+	// hashes of the files imported from outside of the dir, by name. Empty for files which are not
+	// on disk, e.g. google/protobuf/*.proto which are built into the parser.
+	Imports map[string]string
+	// files that failed to compile, reported again when the cache is used
+	Skipped     []skippedProtoFile
+	Descriptors []byte
+}
+
+type skippedProtoFile struct {
+	Name, Error string
+}
+
+// importedFiles returns the hashes of the files imported, directly or not, by the files of the dir,
+// including by the files that failed to compile.
+func importedFiles(p *protoparse.Parser, names []string, fdps []*descriptorpb.FileDescriptorProto, skipped []skippedProtoFile) map[string]string {
+	inDir := map[string]bool{}
+	for _, name := range names {
+		inDir[name] = true
+	}
+	compiled := map[string]*descriptorpb.FileDescriptorProto{}
+	for _, fdp := range fdps {
+		compiled[fdp.GetName()] = fdp
+	}
+	dependencies := func(name string) []string {
+		if fdp, found := compiled[name]; found {
+			return fdp.GetDependency()
+		}
+		// failed to compile, the imports may still be parsed. Import paths are ignored when
+		// parsing alone, files are found by the accessor instead.
+		unlinked := protoparse.Parser{Accessor: func(name string) (io.ReadCloser, error) {
+			b, err := readImport(name, p.ImportPaths)
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(bytes.NewReader(b)), nil
+		}}
+		parsed, err := unlinked.ParseFilesButDoNotLink(name)
+		if err != nil || len(parsed) == 0 {
+			return nil
+		}
+		return parsed[0].GetDependency()
+	}
+
+	res := map[string]string{}
+	var visit func(name string)
+	visit = func(name string) {
+		if _, done := res[name]; done || inDir[name] {
+			return
+		}
+		res[name] = hashImport(name, p.ImportPaths)
+		for _, dep := range dependencies(name) {
+			visit(dep)
+		}
+	}
+	for _, fdp := range fdps {
+		for _, dep := range fdp.GetDependency() {
+			visit(dep)
+		}
+	}
+	for _, s := range skipped {
+		for _, dep := range dependencies(s.Name) {
+			visit(dep)
+		}
+	}
+	return res
+}
+
+// readImport reads the file found first in the import paths, as the parser does.
+func readImport(name string, importPaths []string) ([]byte, error) {
+	for _, dir := range importPaths {
+		if b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name))); err == nil {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+}
+
+func hashImport(name string, importPaths []string) string {
+	b, err := readImport(name, importPaths)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+// valid returns true if none of the imported files changed since the cache was written.
+func (c *protoDirCache) valid(importPaths []string) bool {
+	for name, hash := range c.Imports {
+		if hashImport(name, importPaths) != hash {
+			return false
+		}
+	}
+	return true
+}
+
+// withDependencies returns the file descriptor protos of the files and their transitive
+// dependencies, with dependencies preceding the files that import them.
+func withDependencies(fds []*desc.FileDescriptor) []*descriptorpb.FileDescriptorProto {
+	var res []*descriptorpb.FileDescriptorProto
+	seen := map[string]bool{}
+	var visit func(fd *desc.FileDescriptor)
+	visit = func(fd *desc.FileDescriptor) {
+		if seen[fd.GetName()] {
+			return
+		}
+		seen[fd.GetName()] = true
+		for _, dep := range fd.GetDependencies() {
+			visit(dep)
+		}
+		res = append(res, fd.AsFileDescriptorProto())
+	}
+	sort.Slice(fds, func(i, j int) bool { return fds[i].GetName() < fds[j].GetName() })
+	for _, fd := range fds {
+		visit(fd)
+	}
+	return res
+}
+
+func protoDirCacheFile(key string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "binlog", "proto-dir-"+key+".json")
+}
+
+func readProtoDirCache(filename string) (*protoDirCache, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var c protoDirCache
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *protoDirCache) write(filename string, fdset *descriptorpb.FileDescriptorSet) error {
+	var err error
+	c.Descriptors, err = proto.Marshal(fdset)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
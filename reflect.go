@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/grpcreflect"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// reflectedFileDescriptors fetches the file descriptors of all the services exposed by the server
+// through the server reflection service. Both v1 and v1alpha versions of the reflection service are supported.
+func reflectedFileDescriptors(addr string) ([]*desc.FileDescriptor, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	client := grpcreflect.NewClientAuto(ctx, conn)
+	defer client.Reset()
+
+	services, err := client.ListServices()
+	if err != nil {
+		return nil, fmt.Errorf("listing services: %w", err)
+	}
+	var res []*desc.FileDescriptor
+	for _, s := range services {
+		sd, err := client.ResolveService(s)
+		if err != nil {
+			return nil, fmt.Errorf("resolving service %q: %w", s, err)
+		}
+		res = append(res, sd.GetFile())
+	}
+	return res, nil
+}
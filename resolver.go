@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Kinds of descriptor sources, used to choose between conflicting definitions of the same file.
+var descriptorSourceKinds = []string{"proto", "proto-dir", "descriptor-set", "binary", "reflect"}
+
+// A descriptorResolver collects file descriptors from multiple sources and registers them
+// in dependency order. Identical definitions of the same file are de-duplicated; conflicting
+// definitions are either resolved according to the precedence order or reported as errors.
+type descriptorResolver struct {
+	// source kinds, from highest to lowest precedence. If empty, conflicts are errors.
+	precedence []string
+
+	files map[string]*sourcedFile
+	names []string
+}
+
+type sourcedFile struct {
+	kind   string
+	source string
+	fdp    *descriptorpb.FileDescriptorProto
+}
+
+func newDescriptorResolver(precedence []string) (*descriptorResolver, error) {
+	for _, k := range precedence {
+		if !contains(descriptorSourceKinds, k) {
+			return nil, fmt.Errorf("unknown descriptor source kind %q, must be one of %q", k, descriptorSourceKinds)
+		}
+	}
+	return &descriptorResolver{precedence: precedence, files: map[string]*sourcedFile{}}, nil
+}
+
+// add adds the files coming from a source. The source is a human readable description of
+// where the files come from, used in error messages.
+func (r *descriptorResolver) add(kind, source string, fdps ...*descriptorpb.FileDescriptorProto) error {
+	for _, fdp := range fdps {
+		f := &sourcedFile{kind: kind, source: source, fdp: fdp}
+		old, found := r.files[fdp.GetName()]
+		if !found {
+			r.files[fdp.GetName()] = f
+			r.names = append(r.names, fdp.GetName())
+			continue
+		}
+		if sameFile(old.fdp, fdp) {
+			continue
+		}
+		if len(r.precedence) == 0 {
+			return fmt.Errorf("file %s: conflicting definitions in %s and %s (use --descriptor-precedence to choose)", fdp.GetName(), old.source, f.source)
+		}
+		if r.rank(f.kind) < r.rank(old.kind) {
+			r.files[fdp.GetName()] = f
+			old, f = f, old
+		}
+		log.Printf("file %s: conflicting definitions in %s and %s, using %s", fdp.GetName(), old.source, f.source, old.source)
+	}
+	return nil
+}
+
+// rank returns the position of the kind in the precedence order; kinds not listed rank last.
+func (r *descriptorResolver) rank(kind string) int {
+	for i, k := range r.precedence {
+		if k == kind {
+			return i
+		}
+	}
+	return len(r.precedence)
+}
+
+// register registers all the files, dependencies first. Files that are already registered
+// (e.g. descriptors linked into this binary) cannot be replaced: identical definitions are skipped
+// and conflicting ones are reported and ignored. The same applies to files defining the same
+// symbols under different names, e.g. a file reached from overlapping directories.
+func (r *descriptorResolver) register() error {
+	aliases, err := r.resolveSymbols()
+	if err != nil {
+		return err
+	}
+
+	done := map[string]bool{}
+	var visit func(name string, importedBy *sourcedFile) error
+	visit = func(name string, importedBy *sourcedFile) error {
+		if alias, found := aliases[name]; found {
+			name = alias
+		}
+		if done[name] {
+			return nil
+		}
+		done[name] = true
+
+		if existing, err := protoregistry.GlobalFiles.FindFileByPath(name); err == nil {
+			if f, found := r.files[name]; found && !sameFile(protodesc.ToFileDescriptorProto(existing), f.fdp) {
+				log.Printf("file %s: definition in %s differs from the built-in one, using the built-in one", name, f.source)
+			}
+			return nil
+		}
+		f, found := r.files[name]
+		if !found {
+			if importedBy == nil {
+				return fmt.Errorf("file %s not found", name)
+			}
+			return fmt.Errorf("file %s imported by %s (from %s) not found", name, importedBy.fdp.GetName(), importedBy.source)
+		}
+		for _, dep := range f.fdp.GetDependency() {
+			if err := visit(dep, f); err != nil {
+				return err
+			}
+		}
+		if sym, existing := registeredSymbol(f.fdp); existing != nil {
+			if !sameDefinitions(protodesc.ToFileDescriptorProto(existing), f.fdp) {
+				log.Printf("file %s: %s in %s is already defined by the built-in file %s, using the built-in one", name, sym, f.source, existing.Path())
+			}
+			aliases[name] = existing.Path()
+			return nil
+		}
+		if err := registerFileDescriptor(withAliasedDependencies(f.fdp, aliases)); err != nil {
+			return fmt.Errorf("file %s from %s: %w", name, f.source, err)
+		}
+		return nil
+	}
+	for _, name := range r.names {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveSymbols finds the files defining the same symbols under different names. Identical
+// definitions are de-duplicated; conflicting ones are either resolved according to the precedence
+// order or reported as errors. It returns the name of the file to use instead of each dropped one.
+func (r *descriptorResolver) resolveSymbols() (map[string]string, error) {
+	names := append([]string(nil), r.names...)
+	sort.SliceStable(names, func(i, j int) bool { return r.rank(r.files[names[i]].kind) < r.rank(r.files[names[j]].kind) })
+
+	aliases := map[string]string{}
+	owners := map[protoreflect.FullName]string{}
+	for _, name := range names {
+		f := r.files[name]
+		var (
+			sym   protoreflect.FullName
+			owner string
+		)
+		for _, s := range topLevelSymbols(f.fdp) {
+			if o, found := owners[s]; found {
+				sym, owner = s, o
+				break
+			}
+		}
+		if owner == "" {
+			for _, s := range topLevelSymbols(f.fdp) {
+				owners[s] = name
+			}
+			continue
+		}
+		aliases[name] = owner
+		old := r.files[owner]
+		if sameDefinitions(old.fdp, f.fdp) {
+			continue
+		}
+		if len(r.precedence) == 0 {
+			return nil, fmt.Errorf("%s: conflicting definitions in file %s from %s and file %s from %s (use --descriptor-precedence to choose)", sym, owner, old.source, name, f.source)
+		}
+		log.Printf("%s: conflicting definitions in file %s from %s and file %s from %s, using %s", sym, owner, old.source, name, f.source, old.source)
+	}
+	return aliases, nil
+}
+
+// topLevelSymbols returns the names a file declares in its package.
+func topLevelSymbols(fdp *descriptorpb.FileDescriptorProto) []protoreflect.FullName {
+	pkg := protoreflect.FullName(fdp.GetPackage())
+	var names []protoreflect.FullName
+	for _, m := range fdp.MessageType {
+		names = append(names, pkg.Append(protoreflect.Name(m.GetName())))
+	}
+	for _, e := range fdp.EnumType {
+		names = append(names, pkg.Append(protoreflect.Name(e.GetName())))
+		// enum values are scoped like their enum
+		for _, v := range e.Value {
+			names = append(names, pkg.Append(protoreflect.Name(v.GetName())))
+		}
+	}
+	for _, x := range fdp.Extension {
+		names = append(names, pkg.Append(protoreflect.Name(x.GetName())))
+	}
+	for _, sd := range fdp.Service {
+		names = append(names, pkg.Append(protoreflect.Name(sd.GetName())))
+	}
+	return names
+}
+
+// registeredSymbol returns a symbol of the file, or a parent package of it, that is already
+// registered by another file, which registering the file would panic on.
+func registeredSymbol(fdp *descriptorpb.FileDescriptorProto) (protoreflect.FullName, protoreflect.FileDescriptor) {
+	names := topLevelSymbols(fdp)
+	for pkg := protoreflect.FullName(fdp.GetPackage()); pkg != ""; pkg = pkg.Parent() {
+		names = append(names, pkg)
+	}
+	for _, name := range names {
+		if d, err := protoregistry.GlobalFiles.FindDescriptorByName(name); err == nil {
+			return name, d.ParentFile()
+		}
+	}
+	return "", nil
+}
+
+// withAliasedDependencies returns the file importing the files used instead of the dropped ones.
+func withAliasedDependencies(fdp *descriptorpb.FileDescriptorProto, aliases map[string]string) *descriptorpb.FileDescriptorProto {
+	var clone *descriptorpb.FileDescriptorProto
+	for i, dep := range fdp.GetDependency() {
+		alias, found := aliases[dep]
+		if !found {
+			continue
+		}
+		if clone == nil {
+			clone = proto.Clone(fdp).(*descriptorpb.FileDescriptorProto)
+		}
+		clone.Dependency[i] = alias
+	}
+	if clone == nil {
+		return fdp
+	}
+	return clone
+}
+
+// sameFile returns true if the two files are equivalent, ignoring source code info and
+// json names, which are only populated by some compilers.
+func sameFile(a, b *descriptorpb.FileDescriptorProto) bool {
+	return proto.Equal(normalizeFile(a), normalizeFile(b))
+}
+
+// sameDefinitions returns true if the two files define the same symbols the same way, regardless
+// of their names and of the names they import their dependencies by.
+func sameDefinitions(a, b *descriptorpb.FileDescriptorProto) bool {
+	a, b = normalizeFile(a), normalizeFile(b)
+	for _, fdp := range []*descriptorpb.FileDescriptorProto{a, b} {
+		fdp.Name, fdp.Dependency, fdp.PublicDependency, fdp.WeakDependency = nil, nil, nil, nil
+	}
+	return proto.Equal(a, b)
+}
+
+func normalizeFile(fdp *descriptorpb.FileDescriptorProto) *descriptorpb.FileDescriptorProto {
+	fdp = proto.Clone(fdp).(*descriptorpb.FileDescriptorProto)
+	fdp.SourceCodeInfo = nil
+	for _, m := range fdp.MessageType {
+		clearJSONNames(m)
+	}
+	for _, f := range fdp.Extension {
+		f.JsonName = nil
+	}
+	return fdp
+}
+
+func clearJSONNames(m *descriptorpb.DescriptorProto) {
+	for _, f := range m.Field {
+		f.JsonName = nil
+	}
+	for _, f := range m.Extension {
+		f.JsonName = nil
+	}
+	for _, n := range m.NestedType {
+		clearJSONNames(n)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
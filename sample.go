@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+)
+
+// A rateCap limits the number of calls per second kept for each method matching the pattern,
+// or for every method if the pattern is empty.
+type rateCap struct {
+	pattern string
+	max     int
+}
+
+// A rateKey identifies the calls to a method started during a second.
+type rateKey struct {
+	method string
+	second int64
+}
+
+// rateHorizon is how long the kept calls are counted for after they started. Calls reach the rate
+// caps when they end, so a call that lasts longer than this may exceed the cap of its second.
+const rateHorizon = 3600
+
+func parseRateCap(s string) (rateCap, error) {
+	pattern, max := "", s
+	if i := strings.LastIndex(s, "="); i >= 0 {
+		pattern, max = s[:i], s[i+1:]
+	}
+	n, err := strconv.Atoi(max)
+	if err != nil || n < 0 {
+		return rateCap{}, fmt.Errorf("expected N or glob=N calls per second, got %q", s)
+	}
+	return rateCap{pattern: pattern, max: n}, nil
+}
+
+// sampled returns true if a selected call is kept when sampling. Failed and slow calls are kept if
+// requested, otherwise a fraction of the calls is kept, up to the per method rate caps. Keeping
+// failed or slow calls samples the rest, of which none are kept unless --sample is given.
+func (cmd *FilterCmd) sampled(c *pendingCall) bool {
+	if cmd.KeepErrors && (c.cancel != nil || c.Completed() && c.StatusCode() != codes.OK) {
+		return true
+	}
+	if cmd.KeepSlowerThan > 0 && c.Completed() && c.ElapsedDuration() > cmd.KeepSlowerThan {
+		return true
+	}
+
+	if cmd.Sample > 0 || cmd.KeepErrors || cmd.KeepSlowerThan > 0 {
+		var v float64
+		switch {
+		case cmd.SampleBy == "random":
+			v = rand.Float64()
+		case cmd.SampleBy == "call-id":
+			v = hashFraction(strconv.FormatUint(c.entries[0].CallId, 10))
+		default:
+			v = hashFraction(c.RequestHeaderValue(strings.TrimPrefix(cmd.SampleBy, "metadata:")))
+		}
+		if v >= cmd.Sample {
+			return false
+		}
+	}
+
+	limit := -1
+	for _, r := range cmd.rateCaps {
+		if r.pattern == "" || matchMethod(r.pattern, c.MethodName()) {
+			limit = r.max
+			break
+		}
+	}
+	if limit < 0 {
+		return true
+	}
+	// calls are counted in the second they started in, which is not the order they end in
+	second := c.entries[0].GetTimestamp().AsTime().Unix()
+	if second > cmd.latestSecond {
+		cmd.latestSecond = second
+	}
+	if cmd.latestSecond-cmd.prunedSecond >= rateHorizon {
+		for k := range cmd.rateCounts {
+			if k.second < cmd.latestSecond-rateHorizon {
+				delete(cmd.rateCounts, k)
+			}
+		}
+		cmd.prunedSecond = cmd.latestSecond
+	}
+	key := rateKey{method: c.MethodName(), second: second}
+	if cmd.rateCounts[key] >= limit {
+		return false
+	}
+	cmd.rateCounts[key]++
+	return true
+}
+
+// hashFraction maps s to [0, 1) deterministically, so that logs sampled separately keep the same calls.
+func hashFraction(s string) float64 {
+	h := sha256.Sum256([]byte(s))
+	return float64(binary.BigEndian.Uint64(h[:8])) / (math.MaxUint64 + 1.0)
+}
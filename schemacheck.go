@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/protobuf/proto"
+)
+
+type SchemaCheckCmd struct {
+	CmdCommon
+
+	Output string `optional:"" enum:"table,csv,json" default:"table" help:"Output format (table, csv, json)"`
+}
+
+// schemaCheck counts, for a method and direction, the messages which do not match the descriptors.
+type schemaCheck struct {
+	method, direction string
+	messages          int
+	withUnknown       int
+	decodeErrors      int
+	// truncated by the logger, decoded partially
+	truncated int
+	// unknown field paths with list indices and map keys elided, and how many times they were seen.
+	unknown map[string]int
+}
+
+// elementPattern matches list indices and map keys in unknown field paths.
+var elementPattern = regexp.MustCompile(`\[[^]]*\]`)
+
+func (cmd *SchemaCheckCmd) Run(cli *Context) error {
+	f, err := openFile(cmd.LogInputFile, cli.Follow)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	conversations, err := readConversations(cli, f)
+	if err != nil {
+		return err
+	}
+
+	checks := map[string]*schemaCheck{}
+	var keys []string
+	check := func(method, direction, msgType string, entries []*v1.GrpcLogEntry) {
+		if len(entries) == 0 {
+			return
+		}
+		key := method + " " + direction
+		c, ok := checks[key]
+		if !ok {
+			c = &schemaCheck{method: method, direction: direction, unknown: map[string]int{}}
+			checks[key] = c
+			keys = append(keys, key)
+		}
+		for _, e := range entries {
+			c.messages++
+			if msgType == "" {
+				c.decodeErrors++
+				continue
+			}
+			var (
+				msg proto.Message
+				err error
+			)
+			if e.PayloadTruncated {
+				c.truncated++
+				msg, _, err = parseTruncatedBody(e.GetMessage().GetData(), msgType)
+			} else {
+				msg, err = parseBody(e.GetMessage().GetData(), msgType)
+			}
+			if err != nil {
+				c.decodeErrors++
+				continue
+			}
+			unknown := unknownFields(msg.ProtoReflect())
+			if len(unknown) == 0 {
+				continue
+			}
+			c.withUnknown++
+			seen := map[string]bool{}
+			for _, u := range unknown {
+				path := elementPattern.ReplaceAllString(u.path, "[]")
+				if !seen[path] {
+					seen[path] = true
+					c.unknown[path]++
+				}
+			}
+		}
+	}
+	for _, c := range conversations {
+		if c.CallId() == 0 {
+			continue
+		}
+		// a method without descriptor counts all its messages as decode errors
+		reqType, _ := c.RequestMessageType(cli)
+		respType, _ := c.ResponseMessageType(cli)
+		check(c.MethodName(), "request", reqType, c.requestMessages)
+		check(c.MethodName(), "response", respType, c.responseMessages)
+	}
+	sort.Strings(keys)
+
+	t := &table{header: []string{"Method", "Direction", "Total", "Truncated", "With unknown fields", "Decode errors", "Unknown fields"}}
+	var mismatches int
+	for _, key := range keys {
+		c := checks[key]
+		var fields []string
+		for _, path := range sortedKeys(c.unknown) {
+			fields = append(fields, fmt.Sprintf("%s (%d)", path, c.unknown[path]))
+		}
+		mismatches += c.withUnknown + c.decodeErrors
+		t.append(c.method, c.direction, strconv.Itoa(c.messages), strconv.Itoa(c.truncated), strconv.Itoa(c.withUnknown), strconv.Itoa(c.decodeErrors), strings.Join(fields, ", "))
+	}
+	if err := t.write(os.Stdout, cmd.Output); err != nil {
+		return err
+	}
+	if mismatches > 0 {
+		return fmt.Errorf("%d messages do not match the descriptors", mismatches)
+	}
+	return nil
+}
@@ -1,14 +1,129 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"os"
-	"text/tabwriter"
+	"sort"
+	"strings"
 	"time"
+
+	v1 "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/grpc/codes"
 )
 
 type StatsCmd struct {
 	CmdCommon
+
+	GroupBy              []string      `optional:"" default:"method" help:"Group by one or more of: method, service, status, peer, authority or the name of a request header (e.g. user-agent)"`
+	SplitLatency         bool          `optional:"" help:"Report latency separately for successful and failed calls"`
+	Window               time.Duration `optional:"" help:"Report request rate, error rate and latency percentiles over fixed windows of this duration (e.g. 1m)"`
+	Sizes                bool          `optional:"" help:"Report payload and metadata sizes instead of latency"`
+	Streaming            bool          `optional:"" help:"Report the shape of streaming calls: messages per stream, duration, time to first message and gaps between messages"`
+	Baseline             string        `optional:"" help:"Compare against the stats of this baseline binary log file"`
+	Significance         float64       `optional:"" default:"0.05" help:"p-value below which a difference from the baseline is reported as significant"`
+	MaxErrorRateIncrease float64       `optional:"" help:"Fail if the error rate increased by more than this many percentage points over the baseline"`
+	MaxP99Increase       float64       `optional:"" name:"max-p99-increase" help:"Fail if the p99 latency increased by more than this percentage over the baseline"`
+	Sort                 string        `optional:"" enum:"group,calls,errors,error-rate,p50,p99,bytes" default:"group" help:"Order rows by group (ascending) or by one of calls, errors, error-rate, p50, p99, bytes (descending)"`
+	Output               string        `optional:"" enum:"table,csv,json" default:"table" help:"Output format (table, csv, json)"`
+}
+
+var latencyBuckets = [8]time.Duration{
+	0,
+	time.Millisecond * 50,
+	time.Millisecond * 100,
+	time.Millisecond * 200,
+	time.Millisecond * 500,
+	time.Second * 1,
+	time.Second * 10,
+	time.Second * 100,
+}
+
+type callStats struct {
+	calls     int
+	histogram [8]int
+	errors    int
+	completed int
+	codes     map[codes.Code]int
+
+	// elapsed times of completed calls, used to compute percentiles.
+	latencies []time.Duration
+	sorted    bool
+}
+
+func (s *callStats) record(c conversation) {
+	s.calls++
+	e := c.ElapsedDuration()
+	for i, b := range latencyBuckets {
+		if e >= b {
+			s.histogram[i]++
+		}
+	}
+	if !c.Completed() {
+		return
+	}
+	s.completed++
+	s.latencies = append(s.latencies, e)
+	s.sorted = false
+	if s.codes == nil {
+		s.codes = map[codes.Code]int{}
+	}
+	s.codes[c.StatusCode()]++
+	if c.StatusCode() != codes.OK {
+		s.errors++
+	}
+}
+
+// errorRate returns the fraction of completed calls that returned a non-OK status.
+func (s *callStats) errorRate() float64 {
+	if s.completed == 0 {
+		return 0
+	}
+	return float64(s.errors) / float64(s.completed)
+}
+
+// percentile returns the latency below which the fraction p of completed calls fall.
+func (s *callStats) percentile(p float64) time.Duration {
+	if len(s.latencies) == 0 {
+		return 0
+	}
+	if !s.sorted {
+		sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+		s.sorted = true
+	}
+	return percentile(s.latencies, p)
+}
+
+type number interface {
+	~int | ~int64
+}
+
+// percentile returns the nearest-rank percentile p (between 0 and 1) of the sorted values.
+func percentile[T number](sorted []T, p float64) T {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// renderCodes renders the per-status-code call counts, ordered by code.
+func (s *callStats) renderCodes() string {
+	var keys []codes.Code
+	for c := range s.codes {
+		keys = append(keys, c)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	var parts []string
+	for _, c := range keys {
+		parts = append(parts, fmt.Sprintf("%s:%d", c, s.codes[c]))
+	}
+	return strings.Join(parts, " ")
 }
 
 func (cmd *StatsCmd) Run(cli *Context) error {
@@ -22,44 +137,445 @@ func (cmd *StatsCmd) Run(cli *Context) error {
 	if err != nil {
 		return err
 	}
-	buckets := [8]time.Duration{
-		0,
-		time.Millisecond * 50,
-		time.Millisecond * 100,
-		time.Millisecond * 200,
-		time.Millisecond * 500,
-		time.Second * 1,
-		time.Second * 10,
-		time.Second * 100,
-	}
-	histogramByMethod := map[string][8]int{}
-	for _, c := range conversations {
-		e := c.ElapsedDuration()
-		histogram := histogramByMethod[c.MethodName()]
-		for i, b := range buckets {
-			if e >= b {
-				histogram[i]++
+
+	if cmd.Sizes && cmd.Streaming {
+		return fmt.Errorf("--sizes and --streaming cannot be used together")
+	}
+	if cmd.Window > 0 && (cmd.Sizes || cmd.Streaming) {
+		return fmt.Errorf("--window cannot be used together with --sizes or --streaming")
+	}
+	if cmd.Window > 0 && cmd.Baseline != "" {
+		return fmt.Errorf("--window cannot be used together with --baseline")
+	}
+	if cmd.Window > 0 {
+		return cmd.windowTable(conversations).write(os.Stdout, cmd.Output)
+	}
+
+	summaries := cmd.summarize(conversations)
+	if cmd.Baseline != "" {
+		return cmd.compare(cli, summaries)
+	}
+	switch {
+	case cmd.Output == "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(cmd.report(summaries))
+	case cmd.Sizes:
+		return cmd.sizesTable(summaries).write(os.Stdout, cmd.Output)
+	case cmd.Streaming:
+		return cmd.streamingTable(summaries).write(os.Stdout, cmd.Output)
+	default:
+		return cmd.aggregateTable(summaries).write(os.Stdout, cmd.Output)
+	}
+}
+
+func (cmd *StatsCmd) compare(cli *Context, summaries []*groupSummary) error {
+	if cmd.Sizes || cmd.Streaming {
+		return fmt.Errorf("--baseline cannot be used together with --sizes or --streaming")
+	}
+	f, err := openFile(cmd.Baseline, false)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	conversations, err := readConversations(cli, f)
+	if err != nil {
+		return err
+	}
+	t, failures := cmd.compareTable(cmd.summarize(conversations), summaries)
+	if err := t.write(os.Stdout, cmd.Output); err != nil {
+		return err
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d regressions beyond thresholds", failures)
+	}
+	return nil
+}
+
+// groupColumns returns the titles of the columns identifying a group.
+func (cmd *StatsCmd) groupColumns() []string {
+	var res []string
+	for _, k := range cmd.GroupBy {
+		res = append(res, groupColumn(k))
+	}
+	if cmd.SplitLatency {
+		res = append(res, "Result")
+	}
+	return res
+}
+
+// groupValues returns the values identifying the group the conversation is aggregated into.
+func (cmd *StatsCmd) groupValues(c conversation) []string {
+	var res []string
+	for _, k := range cmd.GroupBy {
+		res = append(res, groupValue(c, k))
+	}
+	if cmd.SplitLatency {
+		switch {
+		case !c.Completed():
+			res = append(res, "")
+		case c.StatusCode() == codes.OK:
+			res = append(res, "ok")
+		default:
+			res = append(res, "failed")
+		}
+	}
+	return res
+}
+
+// A groupSummary holds the statistics of all the calls in a group.
+type groupSummary struct {
+	values []string
+	calls  callStats
+	sizes  sizeStats
+	stream streamStats
+}
+
+// summarize computes the statistics of each group, ordered according to the --sort flag.
+func (cmd *StatsCmd) summarize(conversations []conversation) []*groupSummary {
+	var res []*groupSummary
+	for _, g := range groupConversations(conversations, cmd.groupValues) {
+		s := &groupSummary{values: g.values}
+		for _, c := range g.conversations {
+			s.calls.record(c)
+			s.sizes.record(c)
+			s.stream.record(c)
+		}
+		s.stream.sort()
+		sort.Ints(s.sizes.requestBytes)
+		sort.Ints(s.sizes.responseBytes)
+		res = append(res, s)
+	}
+
+	less := cmd.lessSummaries()
+	sort.SliceStable(res, func(i, j int) bool { return less(res[i], res[j]) })
+	return res
+}
+
+// lessSummaries returns the order of the groups according to the --sort flag.
+func (cmd *StatsCmd) lessSummaries() func(a, b *groupSummary) bool {
+	var metric func(s *groupSummary) float64
+	switch cmd.Sort {
+	case "calls":
+		metric = func(s *groupSummary) float64 { return float64(s.calls.calls) }
+	case "errors":
+		metric = func(s *groupSummary) float64 { return float64(s.calls.errors) }
+	case "error-rate":
+		metric = func(s *groupSummary) float64 { return s.calls.errorRate() }
+	case "p50":
+		metric = func(s *groupSummary) float64 { return float64(s.calls.percentile(0.5)) }
+	case "p99":
+		metric = func(s *groupSummary) float64 { return float64(s.calls.percentile(0.99)) }
+	case "bytes":
+		metric = func(s *groupSummary) float64 { return float64(s.sizes.totalBytes()) }
+	}
+	return func(a, b *groupSummary) bool {
+		if metric != nil {
+			if ma, mb := metric(a), metric(b); ma != mb {
+				return ma > mb
 			}
 		}
-		histogramByMethod[c.MethodName()] = histogram
+		return lessValues(a.values, b.values)
 	}
+}
 
-	var w tabwriter.Writer
-	w.Init(os.Stdout, 0, 8, 0, '\t', 0)
-	fmt.Fprintf(&w, "Method\t[≥0s]\t[≥0.05s]\t[≥0.1s]\t[≥0.2s]\t[≥0.5s]\t[≥1s]\t[≥10s]\t[≥100s]\t[errors]\n")
-	for method, histogram := range histogramByMethod {
-		b0 := histogram[0]
-		b1 := histogram[1]
-		b2 := histogram[2]
-		b3 := histogram[3]
-		b4 := histogram[4]
-		b5 := histogram[5]
-		b6 := histogram[6]
-		b7 := histogram[7]
+// lessValues orders group values lexicographically.
+func lessValues(a, b []string) bool {
+	for i := range a {
+		if i >= len(b) {
+			return false
+		}
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
 
-		fmt.Fprintf(&w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n", method, b0, b1, b2, b3, b4, b5, b6, b7)
+func (cmd *StatsCmd) aggregateTable(summaries []*groupSummary) *table {
+	header := append(cmd.groupColumns(), "[≥0s]", "[≥0.05s]", "[≥0.1s]", "[≥0.2s]", "[≥0.5s]", "[≥1s]", "[≥10s]", "[≥100s]", "[errors]", "[error rate]", "[codes]")
+	t := &table{header: header}
+	for _, g := range summaries {
+		s := &g.calls
+		row := append([]string{}, g.values...)
+		for _, n := range s.histogram {
+			row = append(row, fmt.Sprint(n))
+		}
+		row = append(row, fmt.Sprint(s.errors), fmt.Sprintf("%.2f%%", s.errorRate()*100), s.renderCodes())
+		t.append(row...)
 	}
-	w.Flush()
+	return t
+}
 
-	return nil
+// groupReport is the machine readable form of a groupSummary.
+type groupReport struct {
+	Group     map[string]string `json:"group"`
+	Calls     int               `json:"calls"`
+	Completed int               `json:"completed"`
+	Errors    int               `json:"errors"`
+	ErrorRate float64           `json:"errorRate"`
+	Codes     map[string]int    `json:"codes"`
+	Latency   latencyReport     `json:"latency"`
+	Histogram []bucketReport    `json:"histogram"`
+	Sizes     sizeReport        `json:"sizes"`
+	Streaming *streamReport     `json:"streaming,omitempty"`
+}
+
+// latencyReport contains latency percentiles, in seconds.
+type latencyReport struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// bucketReport is the number of calls that took at least the given number of seconds.
+type bucketReport struct {
+	Min   float64 `json:"min"`
+	Count int     `json:"count"`
+}
+
+type sizeReport struct {
+	RequestMessages      int `json:"requestMessages"`
+	ResponseMessages     int `json:"responseMessages"`
+	RequestBytesP50      int `json:"requestBytesP50"`
+	RequestBytesP99      int `json:"requestBytesP99"`
+	ResponseBytesP50     int `json:"responseBytesP50"`
+	ResponseBytesP99     int `json:"responseBytesP99"`
+	MaxRequestMessage    int `json:"maxRequestMessage"`
+	MaxResponseMessage   int `json:"maxResponseMessage"`
+	TotalBytes           int `json:"totalBytes"`
+	TruncatedMessages    int `json:"truncatedMessages"`
+	HeaderMetadataBytes  int `json:"headerMetadataBytes"`
+	TrailerMetadataBytes int `json:"trailerMetadataBytes"`
+}
+
+func (cmd *StatsCmd) report(summaries []*groupSummary) []groupReport {
+	columns := append([]string{}, cmd.GroupBy...)
+	if cmd.SplitLatency {
+		columns = append(columns, "result")
+	}
+	res := []groupReport{}
+	for _, g := range summaries {
+		s, z := &g.calls, &g.sizes
+		r := groupReport{
+			Group:     map[string]string{},
+			Calls:     s.calls,
+			Completed: s.completed,
+			Errors:    s.errors,
+			ErrorRate: s.errorRate(),
+			Codes:     map[string]int{},
+			Latency: latencyReport{
+				P50: s.percentile(0.5).Seconds(),
+				P90: s.percentile(0.9).Seconds(),
+				P99: s.percentile(0.99).Seconds(),
+				Max: s.percentile(1).Seconds(),
+			},
+			Sizes: sizeReport{
+				RequestMessages:      z.requestMessages,
+				ResponseMessages:     z.responseMessages,
+				RequestBytesP50:      percentile(z.requestBytes, 0.5),
+				RequestBytesP99:      percentile(z.requestBytes, 0.99),
+				ResponseBytesP50:     percentile(z.responseBytes, 0.5),
+				ResponseBytesP99:     percentile(z.responseBytes, 0.99),
+				MaxRequestMessage:    z.maxRequestMessage,
+				MaxResponseMessage:   z.maxResponseMessage,
+				TotalBytes:           z.totalBytes(),
+				TruncatedMessages:    z.truncated,
+				HeaderMetadataBytes:  z.headerBytes,
+				TrailerMetadataBytes: z.trailerBytes,
+			},
+			Streaming: g.stream.report(),
+		}
+		for i, c := range columns {
+			r.Group[c] = g.values[i]
+		}
+		for c, n := range s.codes {
+			r.Codes[c.String()] = n
+		}
+		for i, n := range s.histogram {
+			r.Histogram = append(r.Histogram, bucketReport{Min: latencyBuckets[i].Seconds(), Count: n})
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+type windowRow struct {
+	start  time.Time
+	values []string
+	stats  *callStats
+}
+
+// windowTable aggregates conversations into fixed windows, based on the time the call started.
+// Every group has a row for every window between the first and the last call, even without calls.
+func (cmd *StatsCmd) windowTable(conversations []conversation) *table {
+	type groupWindows struct {
+		values   []string
+		byWindow map[time.Time]*callStats
+	}
+	var (
+		groups      []groupWindows
+		first, last time.Time
+	)
+	for _, g := range groupConversations(conversations, cmd.groupValues) {
+		byWindow := map[time.Time]*callStats{}
+		for _, c := range g.conversations {
+			if c.requestHeader == nil {
+				continue
+			}
+			start := c.requestHeader.GetTimestamp().AsTime().Truncate(cmd.Window)
+			if first.IsZero() || start.Before(first) {
+				first = start
+			}
+			if start.After(last) {
+				last = start
+			}
+			s, found := byWindow[start]
+			if !found {
+				s = &callStats{}
+				byWindow[start] = s
+			}
+			s.record(c)
+		}
+		groups = append(groups, groupWindows{values: g.values, byWindow: byWindow})
+	}
+	var rows []windowRow
+	for _, g := range groups {
+		if len(g.byWindow) == 0 {
+			continue
+		}
+		for start := first; !start.After(last); start = start.Add(cmd.Window) {
+			s, found := g.byWindow[start]
+			if !found {
+				s = &callStats{}
+			}
+			rows = append(rows, windowRow{start: start, values: g.values, stats: s})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if !rows[i].start.Equal(rows[j].start) {
+			return rows[i].start.Before(rows[j].start)
+		}
+		return lessValues(rows[i].values, rows[j].values)
+	})
+
+	header := append([]string{"Window"}, cmd.groupColumns()...)
+	header = append(header, "Requests", "Rate [req/s]", "Errors", "Error rate", "p50", "p99")
+	t := &table{header: header}
+	for _, r := range rows {
+		s := r.stats
+		row := append([]string{r.start.Format("2006/01/02 15:04:05")}, r.values...)
+		row = append(row,
+			fmt.Sprint(s.calls),
+			fmt.Sprintf("%.2f", float64(s.calls)/cmd.Window.Seconds()),
+			fmt.Sprint(s.errors),
+			fmt.Sprintf("%.2f%%", s.errorRate()*100),
+			fmt.Sprint(s.percentile(0.5)),
+			fmt.Sprint(s.percentile(0.99)),
+		)
+		t.append(row...)
+	}
+	return t
+}
+
+type sizeStats struct {
+	calls            int
+	requestMessages  int
+	responseMessages int
+	truncated        int
+
+	// total payload bytes per call, as originally sent (i.e. Message.Length, even if the payload was truncated).
+	requestBytes  []int
+	responseBytes []int
+
+	maxRequestMessage  int
+	maxResponseMessage int
+
+	headerBytes  int
+	trailerBytes int
+}
+
+func (s *sizeStats) record(c conversation) {
+	s.calls++
+	s.requestMessages += len(c.requestMessages)
+	s.responseMessages += len(c.responseMessages)
+
+	var req, res int
+	for _, m := range c.requestMessages {
+		n := int(m.GetMessage().GetLength())
+		req += n
+		if n > s.maxRequestMessage {
+			s.maxRequestMessage = n
+		}
+		if m.PayloadTruncated {
+			s.truncated++
+		}
+	}
+	for _, m := range c.responseMessages {
+		n := int(m.GetMessage().GetLength())
+		res += n
+		if n > s.maxResponseMessage {
+			s.maxResponseMessage = n
+		}
+		if m.PayloadTruncated {
+			s.truncated++
+		}
+	}
+	s.requestBytes = append(s.requestBytes, req)
+	s.responseBytes = append(s.responseBytes, res)
+
+	s.headerBytes += metadataSize(c.requestHeader.GetClientHeader().GetMetadata())
+	s.headerBytes += metadataSize(c.responseHeader.GetServerHeader().GetMetadata())
+	s.trailerBytes += metadataSize(c.responseTrailer.GetTrailer().GetMetadata())
+}
+
+func (s *sizeStats) totalBytes() int {
+	var n int
+	for _, b := range s.requestBytes {
+		n += b
+	}
+	for _, b := range s.responseBytes {
+		n += b
+	}
+	return n
+}
+
+// metadataSize returns the size of the metadata keys and values.
+func metadataSize(m *v1.Metadata) int {
+	var n int
+	for _, e := range m.GetEntry() {
+		n += len(e.GetKey()) + len(e.GetValue())
+	}
+	return n
+}
+
+func (cmd *StatsCmd) sizesTable(summaries []*groupSummary) *table {
+	perCall := func(n int, s *sizeStats) string {
+		return fmt.Sprintf("%.1f", float64(n)/float64(s.calls))
+	}
+	header := append(cmd.groupColumns(), "Calls", "Req msgs/call", "Res msgs/call", "Req bytes/call p50", "p99", "Res bytes/call p50", "p99", "Max req msg", "Max res msg", "Total bytes", "Truncated", "Header bytes/call", "Trailer bytes/call")
+	t := &table{header: header}
+	for _, g := range summaries {
+		s := &g.sizes
+		row := append([]string{}, g.values...)
+		row = append(row,
+			fmt.Sprint(s.calls),
+			perCall(s.requestMessages, s),
+			perCall(s.responseMessages, s),
+			fmt.Sprint(percentile(s.requestBytes, 0.5)),
+			fmt.Sprint(percentile(s.requestBytes, 0.99)),
+			fmt.Sprint(percentile(s.responseBytes, 0.5)),
+			fmt.Sprint(percentile(s.responseBytes, 0.99)),
+			fmt.Sprint(s.maxRequestMessage),
+			fmt.Sprint(s.maxResponseMessage),
+			fmt.Sprint(s.totalBytes()),
+			fmt.Sprint(s.truncated),
+			perCall(s.headerBytes, s),
+			perCall(s.trailerBytes, s),
+		)
+		t.append(row...)
+	}
+	return t
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+)
+
+// streamStats holds the shape of the streams of a group of calls.
+type streamStats struct {
+	requestMessages  []int
+	responseMessages []int
+
+	durations []time.Duration
+	// time between the client header and the first response message.
+	firstMessage []time.Duration
+	// time between consecutive messages in the same direction.
+	requestGaps  []time.Duration
+	responseGaps []time.Duration
+}
+
+func (s *streamStats) record(c conversation) {
+	s.requestMessages = append(s.requestMessages, len(c.requestMessages))
+	s.responseMessages = append(s.responseMessages, len(c.responseMessages))
+	if c.requestHeader == nil {
+		return
+	}
+	if c.Completed() {
+		s.durations = append(s.durations, c.ElapsedDuration())
+	}
+	if len(c.responseMessages) > 0 {
+		s.firstMessage = append(s.firstMessage, c.responseMessages[0].GetTimestamp().AsTime().Sub(c.requestHeader.GetTimestamp().AsTime()))
+	}
+	s.requestGaps = appendGaps(s.requestGaps, c.requestMessages)
+	s.responseGaps = appendGaps(s.responseGaps, c.responseMessages)
+}
+
+func appendGaps(gaps []time.Duration, entries []*v1.GrpcLogEntry) []time.Duration {
+	for i := 1; i < len(entries); i++ {
+		gaps = append(gaps, entries[i].GetTimestamp().AsTime().Sub(entries[i-1].GetTimestamp().AsTime()))
+	}
+	return gaps
+}
+
+// sort must be called after all the calls have been recorded and before computing percentiles.
+func (s *streamStats) sort() {
+	sort.Ints(s.requestMessages)
+	sort.Ints(s.responseMessages)
+	for _, d := range [][]time.Duration{s.durations, s.firstMessage, s.requestGaps, s.responseGaps} {
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	}
+}
+
+// kind infers whether the calls are unary, client, server or bidi streaming,
+// based on the maximum number of messages observed in each direction.
+func (s *streamStats) kind() string {
+	clientStreaming := percentile(s.requestMessages, 1) > 1
+	serverStreaming := percentile(s.responseMessages, 1) > 1
+	switch {
+	case clientStreaming && serverStreaming:
+		return "bidi"
+	case clientStreaming:
+		return "client"
+	case serverStreaming:
+		return "server"
+	default:
+		return "unary"
+	}
+}
+
+func (cmd *StatsCmd) streamingTable(summaries []*groupSummary) *table {
+	header := append(cmd.groupColumns(), "Kind", "Calls", "Req msgs p50", "max", "Res msgs p50", "max", "Duration p50", "p99", "First msg p50", "p99", "Req gap p50", "p99", "Res gap p50", "p99")
+	t := &table{header: header}
+	for _, g := range summaries {
+		s := &g.stream
+		if s.kind() == "unary" {
+			continue
+		}
+		row := append([]string{}, g.values...)
+		row = append(row,
+			s.kind(),
+			fmt.Sprint(len(s.requestMessages)),
+			fmt.Sprint(percentile(s.requestMessages, 0.5)),
+			fmt.Sprint(percentile(s.requestMessages, 1)),
+			fmt.Sprint(percentile(s.responseMessages, 0.5)),
+			fmt.Sprint(percentile(s.responseMessages, 1)),
+			fmt.Sprint(percentile(s.durations, 0.5)),
+			fmt.Sprint(percentile(s.durations, 0.99)),
+			fmt.Sprint(percentile(s.firstMessage, 0.5)),
+			fmt.Sprint(percentile(s.firstMessage, 0.99)),
+			fmt.Sprint(percentile(s.requestGaps, 0.5)),
+			fmt.Sprint(percentile(s.requestGaps, 0.99)),
+			fmt.Sprint(percentile(s.responseGaps, 0.5)),
+			fmt.Sprint(percentile(s.responseGaps, 0.99)),
+		)
+		t.append(row...)
+	}
+	return t
+}
+
+// streamReport is the machine readable form of streamStats. Durations are in seconds.
+type streamReport struct {
+	Kind                string  `json:"kind"`
+	RequestMessagesP50  int     `json:"requestMessagesP50"`
+	RequestMessagesMax  int     `json:"requestMessagesMax"`
+	ResponseMessagesP50 int     `json:"responseMessagesP50"`
+	ResponseMessagesMax int     `json:"responseMessagesMax"`
+	DurationP50         float64 `json:"durationP50"`
+	DurationP99         float64 `json:"durationP99"`
+	FirstMessageP50     float64 `json:"firstMessageP50"`
+	FirstMessageP99     float64 `json:"firstMessageP99"`
+	RequestGapP50       float64 `json:"requestGapP50"`
+	RequestGapP99       float64 `json:"requestGapP99"`
+	ResponseGapP50      float64 `json:"responseGapP50"`
+	ResponseGapP99      float64 `json:"responseGapP99"`
+}
+
+// report returns nil for unary calls.
+func (s *streamStats) report() *streamReport {
+	if s.kind() == "unary" {
+		return nil
+	}
+	return &streamReport{
+		Kind:                s.kind(),
+		RequestMessagesP50:  percentile(s.requestMessages, 0.5),
+		RequestMessagesMax:  percentile(s.requestMessages, 1),
+		ResponseMessagesP50: percentile(s.responseMessages, 0.5),
+		ResponseMessagesMax: percentile(s.responseMessages, 1),
+		DurationP50:         percentile(s.durations, 0.5).Seconds(),
+		DurationP99:         percentile(s.durations, 0.99).Seconds(),
+		FirstMessageP50:     percentile(s.firstMessage, 0.5).Seconds(),
+		FirstMessageP99:     percentile(s.firstMessage, 0.99).Seconds(),
+		RequestGapP50:       percentile(s.requestGaps, 0.5).Seconds(),
+		RequestGapP99:       percentile(s.requestGaps, 0.99).Seconds(),
+		ResponseGapP50:      percentile(s.responseGaps, 0.5).Seconds(),
+		ResponseGapP99:      percentile(s.responseGaps, 0.99).Seconds(),
+	}
+}
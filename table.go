@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// A table is a list of rows sharing the same header, which can be rendered
+// either as aligned columns or as CSV.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+func (t *table) append(row ...string) {
+	t.rows = append(t.rows, row)
+}
+
+func (t *table) write(w io.Writer, format string) error {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(t.header); err != nil {
+			return err
+		}
+		if err := cw.WriteAll(t.rows); err != nil {
+			return err
+		}
+		return cw.Error()
+	case "json":
+		res := []map[string]string{}
+		for _, r := range t.rows {
+			obj := map[string]string{}
+			for i, h := range t.header {
+				obj[h] = r[i]
+			}
+			res = append(res, obj)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	case "table", "":
+		var tw tabwriter.Writer
+		tw.Init(w, 0, 8, 0, '\t', 0)
+		fmt.Fprintf(&tw, "%s\n", strings.Join(t.header, "\t"))
+		for _, r := range t.rows {
+			fmt.Fprintf(&tw, "%s\n", strings.Join(r, "\t"))
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unsupported output format %q", format)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// An unknownField is a field found on the wire which is not part of the message descriptor,
+// usually because the descriptor is older than the one used by the peer that produced the message.
+type unknownField struct {
+	// path of the field, as dot separated field names followed by the unknown field number, e.g. "inner.9".
+	path  string
+	typ   protowire.Type
+	value string
+}
+
+func (f unknownField) String() string {
+	return fmt.Sprintf("%s:%s", f.path, f.value)
+}
+
+// unknownFields returns the unknown fields of the message and of all the messages nested in it.
+func unknownFields(m protoreflect.Message) []unknownField {
+	return appendUnknownFields(nil, "", m)
+}
+
+func appendUnknownFields(res []unknownField, prefix string, m protoreflect.Message) []unknownField {
+	res = appendRawFields(res, prefix, m.GetUnknown())
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		name := prefix + string(fd.Name())
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Message() == nil {
+				break
+			}
+			v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+				res = appendUnknownFields(res, fmt.Sprintf("%s[%v].", name, k.Interface()), mv.Message())
+				return true
+			})
+		case fd.IsList():
+			if fd.Message() == nil {
+				break
+			}
+			l := v.List()
+			for i := 0; i < l.Len(); i++ {
+				res = appendUnknownFields(res, fmt.Sprintf("%s[%d].", name, i), l.Get(i).Message())
+			}
+		case fd.Message() != nil:
+			res = appendUnknownFields(res, name+".", v.Message())
+		}
+		return true
+	})
+	return res
+}
+
+// appendRawFields renders the raw wire format fields in b.
+func appendRawFields(res []unknownField, prefix string, b []byte) []unknownField {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return append(res, unknownField{path: prefix + "?", value: "invalid"})
+		}
+		b = b[n:]
+		f := unknownField{path: fmt.Sprintf("%s%d", prefix, num), typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			v, m := protowire.ConsumeVarint(b)
+			n = m
+			f.value = fmt.Sprintf("varint=%d", v)
+		case protowire.Fixed32Type:
+			v, m := protowire.ConsumeFixed32(b)
+			n = m
+			f.value = fmt.Sprintf("fixed32=%#x", v)
+		case protowire.Fixed64Type:
+			v, m := protowire.ConsumeFixed64(b)
+			n = m
+			f.value = fmt.Sprintf("fixed64=%#x", v)
+		case protowire.BytesType:
+			v, m := protowire.ConsumeBytes(b)
+			n = m
+			f.value = fmt.Sprintf("bytes=%s", quoteBytes(v))
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			f.value = "group"
+		}
+		if n < 0 {
+			return append(res, unknownField{path: f.path, typ: typ, value: "invalid"})
+		}
+		b = b[n:]
+		res = append(res, f)
+	}
+	return res
+}
+
+func quoteBytes(b []byte) string {
+	const max = 64
+	if len(b) > max {
+		return fmt.Sprintf("%q...(%d bytes)", b[:max], len(b))
+	}
+	return fmt.Sprintf("%q", b)
+}
+
+func renderUnknownFields(fields []unknownField) string {
+	var parts []string
+	for _, f := range fields {
+		parts = append(parts, f.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+// copyUnknownFields adds the unknown fields of src and of the messages nested in it to the
+// corresponding messages of dst, matching list elements by index and map entries by key.
+// Unknown fields of messages that are not in dst are dropped along with them.
+func copyUnknownFields(dst, src protoreflect.Message) {
+	if u := src.GetUnknown(); len(u) > 0 {
+		dst.SetUnknown(append(append(protoreflect.RawFields(nil), dst.GetUnknown()...), u...))
+	}
+	src.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if !dst.Has(fd) {
+			return true
+		}
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Message() == nil {
+				break
+			}
+			m := dst.Mutable(fd).Map()
+			v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+				if m.Has(k) {
+					copyUnknownFields(m.Mutable(k).Message(), mv.Message())
+				}
+				return true
+			})
+		case fd.IsList():
+			if fd.Message() == nil {
+				break
+			}
+			l, sl := dst.Mutable(fd).List(), v.List()
+			for i := 0; i < l.Len() && i < sl.Len(); i++ {
+				copyUnknownFields(l.Get(i).Message(), sl.Get(i).Message())
+			}
+		case fd.Message() != nil:
+			copyUnknownFields(dst.Mutable(fd).Message(), v.Message())
+		}
+		return true
+	})
+}
@@ -4,8 +4,6 @@ import (
 	"fmt"
 	"os"
 	"text/tabwriter"
-
-	"google.golang.org/grpc/codes"
 )
 
 type ViewCmd struct {
@@ -44,8 +42,7 @@ func (cmd *ViewCmd) Run(cli *Context) error {
 			}
 		}
 
-		statusCode := codes.Code(c.responseTrailer.GetTrailer().GetStatusCode())
-		fmt.Fprintf(&w, "%d\t%s\t%s\t%s\t%s\n", c.CallId(), c.Timestamp(), c.Elapsed(), c.MethodName(), statusCode)
+		fmt.Fprintf(&w, "%d\t%s\t%s\t%s\t%s\n", c.CallId(), c.Timestamp(), c.Elapsed(), c.MethodName(), c.StatusCode())
 
 		if cmd.Headers {
 			if m := c.requestHeader.GetClientHeader().GetMetadata(); len(m.GetEntry()) > 0 {